@@ -0,0 +1,325 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../types"
+)
+
+const (
+	// defaultSampleDelay is how long a request waits for a second sample when no recent one exists to compute cpu
+	// usage from
+	defaultSampleDelay = time.Second
+	// maxSampleAge is how old a previous sample can be to compute cpu usage from, older ones are forgotten
+	maxSampleAge = 5 * time.Minute
+)
+
+// containerIDPattern matches the container IDs that are safe to use in a cgroup path
+var containerIDPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// containerSample is a reading of the cgroup of a container together with the host cpu time it was taken at
+type containerSample struct {
+	Timestamp time.Time
+	Cgroup    cgroupStats
+	System    systemCPU
+}
+
+// hostSample
+type hostSample struct {
+	Timestamp time.Time
+	System    systemCPU
+}
+
+// Agent reads the resource usage of the host it runs on and of its containers from /proc and the cgroup hierarchy
+//
+// CPU usage is computed from the difference between two samples, so every reading is remembered to serve as the
+// previous sample of the next request
+type Agent struct {
+	procRoot    string
+	cgroupRoot  string
+	hostname    string
+	sampleDelay time.Duration
+
+	lock             sync.Mutex
+	containerSamples map[string]containerSample
+	hostSample       *hostSample
+}
+
+// New creates an Agent reading the proc filesystem mounted at procRoot and the cgroup hierarchy mounted at cgroupRoot,
+// which differ from /proc and /sys/fs/cgroup when the agent runs in a container with the host ones bind mounted
+func New(procRoot string, cgroupRoot string) *Agent {
+	hostname, _ := os.Hostname()
+
+	return &Agent{
+		procRoot:         procRoot,
+		cgroupRoot:       cgroupRoot,
+		hostname:         hostname,
+		sampleDelay:      defaultSampleDelay,
+		containerSamples: map[string]containerSample{},
+	}
+}
+
+// ContainerStats returns the stats of a container in the format of the docker engine stats api
+func (a *Agent) ContainerStats(ctx context.Context, containerID string) (types.ContainerStatsRaw, error) {
+	var result types.ContainerStatsRaw
+
+	if !containerIDPattern.MatchString(containerID) {
+		return result, fmt.Errorf("invalid container id %q", containerID)
+	}
+
+	previous, ok := a.previousContainerSample(containerID)
+
+	if !ok {
+		sample, err := a.readContainerSample(containerID)
+
+		if err != nil {
+			return result, err
+		}
+
+		previous = sample
+
+		if err := sleep(ctx, a.sampleDelay); err != nil {
+			return result, err
+		}
+	}
+
+	current, err := a.readContainerSample(containerID)
+
+	if err != nil {
+		return result, err
+	}
+
+	a.lock.Lock()
+	a.containerSamples[containerID] = current
+	a.forgetOldSamples(current.Timestamp)
+	a.lock.Unlock()
+
+	memoryLimit := current.Cgroup.MemoryLimit
+	if memoryLimit == 0 {
+		// like the docker engine, report the memory of the host as the limit of an unlimited container
+		if total, _, err := readMemInfo(a.procRoot); err == nil {
+			memoryLimit = total
+		}
+	}
+
+	result.ID = containerID
+	result.PreCPUStats.CPUUsage.TotalUsage = previous.Cgroup.CPUUsage
+	result.PreCPUStats.SystemCPUUsage = previous.System.Usage
+	result.PreCPUStats.OnlineCPUs = previous.System.OnlineCPUs
+	result.CPUStats.CPUUsage.TotalUsage = current.Cgroup.CPUUsage
+	result.CPUStats.SystemCPUUsage = current.System.Usage
+	result.CPUStats.OnlineCPUs = current.System.OnlineCPUs
+	result.MemoryStats.Usage = current.Cgroup.MemoryUsage
+	result.MemoryStats.Limit = memoryLimit
+	result.MemoryStats.Stats.RSS = current.Cgroup.RSS
+	result.MemoryStats.Stats.Cache = current.Cgroup.Cache
+	result.MemoryStats.Stats.TotalInactiveFile = current.Cgroup.TotalInactiveFile
+	result.MemoryStats.Stats.InactiveFile = current.Cgroup.InactiveFile
+
+	return result, nil
+}
+
+// HostStats returns the resource usage of the host
+func (a *Agent) HostStats(ctx context.Context) (types.HostStats, error) {
+	result := types.HostStats{Hostname: a.hostname}
+
+	a.lock.Lock()
+	previous := a.hostSample
+	a.lock.Unlock()
+
+	if previous == nil || time.Since(previous.Timestamp) > maxSampleAge {
+		system, err := readSystemCPU(a.procRoot)
+
+		if err != nil {
+			return result, err
+		}
+
+		previous = &hostSample{Timestamp: time.Now(), System: system}
+
+		if err := sleep(ctx, a.sampleDelay); err != nil {
+			return result, err
+		}
+	}
+
+	system, err := readSystemCPU(a.procRoot)
+
+	if err != nil {
+		return result, err
+	}
+
+	a.lock.Lock()
+	a.hostSample = &hostSample{Timestamp: time.Now(), System: system}
+	a.lock.Unlock()
+
+	if usageDelta := system.Usage - previous.System.Usage; usageDelta > 0 {
+		idleDelta := system.Idle - previous.System.Idle
+		result.CPU = float64(usageDelta-idleDelta) / float64(usageDelta) * 100.0
+	}
+
+	result.OnlineCPUs = system.OnlineCPUs
+
+	if result.MemoryTotal, result.MemoryAvailable, err = readMemInfo(a.procRoot); err != nil {
+		return result, err
+	}
+
+	if result.MemoryTotal > 0 {
+		result.Memory = float64(result.MemoryTotal-result.MemoryAvailable) / float64(result.MemoryTotal) * 100.0
+	}
+
+	if result.Load1, result.Load5, result.Load15, err = readLoadAvg(a.procRoot); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Handler returns the http api of the agent:
+//
+//	GET /containers/{id}/stats returns the types.ContainerStatsRaw of a container
+//	GET /host/stats returns the types.HostStats of the host
+//	GET /healthz returns 200 when the agent is running
+func (a *Agent) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/containers/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/containers/"), "/")
+
+		if len(parts) != 2 || parts[1] != "stats" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		stats, err := a.ContainerStats(r.Context(), parts[0])
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(w, stats)
+	})
+
+	mux.HandleFunc("/host/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := a.HostStats(r.Context())
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		writeJSON(w, stats)
+	})
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	return mux
+}
+
+// Serve runs the http api of the agent on addr until ctx is cancelled, over https if certFile and keyFile are set
+func (a *Agent) Serve(ctx context.Context, addr string, certFile string, keyFile string) error {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: a.Handler(),
+	}
+
+	errs := make(chan error, 1)
+
+	go func() {
+		if certFile != "" {
+			errs <- server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	log.Infof("agent listening on %s", addr)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return server.Shutdown(shutdownCtx)
+}
+
+// previousContainerSample returns the last sample of a container if it is recent enough to compute cpu usage from
+func (a *Agent) previousContainerSample(containerID string) (containerSample, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	s, ok := a.containerSamples[containerID]
+
+	if !ok || time.Since(s.Timestamp) > maxSampleAge {
+		return containerSample{}, false
+	}
+
+	return s, true
+}
+
+// readContainerSample
+func (a *Agent) readContainerSample(containerID string) (containerSample, error) {
+	cgroup, err := readCgroupStats(a.cgroupRoot, containerID)
+
+	if err != nil {
+		return containerSample{}, err
+	}
+
+	system, err := readSystemCPU(a.procRoot)
+
+	if err != nil {
+		return containerSample{}, err
+	}
+
+	return containerSample{Timestamp: time.Now(), Cgroup: cgroup, System: system}, nil
+}
+
+// forgetOldSamples drops the samples of the containers that have not been asked for since maxSampleAge, which are
+// likely gone
+func (a *Agent) forgetOldSamples(now time.Time) {
+	for id, s := range a.containerSamples {
+		if now.Sub(s.Timestamp) > maxSampleAge {
+			delete(a.containerSamples, id)
+		}
+	}
+}
+
+// sleep waits for d or until ctx is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeJSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("cannot write response: %s", err)
+	}
+}
@@ -0,0 +1,270 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"../types"
+)
+
+// writeFiles creates the files of a fake proc or cgroup tree under root
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestAgent creates an Agent over a fake proc tree of two cpus with 4GiB of memory
+func newTestAgent(t *testing.T, cgroupFiles map[string]string) *Agent {
+	root, err := ioutil.TempDir("", "agent")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	procRoot := filepath.Join(root, "proc")
+	cgroupRoot := filepath.Join(root, "cgroup")
+
+	writeFiles(t, procRoot, map[string]string{
+		"stat":    "cpu  100 0 100 700 100 0 0 0 0 0\ncpu0 50 0 50 350 50 0 0 0 0 0\ncpu1 50 0 50 350 50 0 0 0 0 0\nintr 1\n",
+		"meminfo": "MemTotal:        4194304 kB\nMemFree:         1048576 kB\nMemAvailable:    3145728 kB\n",
+		"loadavg": "0.50 0.25 0.10 1/100 1000\n",
+	})
+	writeFiles(t, cgroupRoot, cgroupFiles)
+
+	a := New(procRoot, cgroupRoot)
+	a.sampleDelay = 0
+
+	return a
+}
+
+func TestContainerStats(t *testing.T) {
+	tests := []struct {
+		name        string
+		cgroupFiles map[string]string
+		want        types.ContainerStatsRaw
+	}{
+		{
+			name: "cgroup v1 cgroupfs driver",
+			cgroupFiles: map[string]string{
+				"cpuacct/docker/abc/cpuacct.usage":        "5000000000\n",
+				"memory/docker/abc/memory.usage_in_bytes": "104857600\n",
+				"memory/docker/abc/memory.limit_in_bytes": "209715200\n",
+				"memory/docker/abc/memory.stat":           "cache 1\ntotal_rss 83886080\ntotal_cache 20971520\ntotal_inactive_file 10485760\n",
+			},
+			want: func() (s types.ContainerStatsRaw) {
+				s.MemoryStats.Usage = 104857600
+				s.MemoryStats.Limit = 209715200
+				s.MemoryStats.Stats.RSS = 83886080
+				s.MemoryStats.Stats.Cache = 20971520
+				s.MemoryStats.Stats.TotalInactiveFile = 10485760
+				return
+			}(),
+		},
+		{
+			name: "cgroup v1 unlimited memory",
+			cgroupFiles: map[string]string{
+				"cpuacct/system.slice/docker-abc.scope/cpuacct.usage":        "5000000000\n",
+				"memory/system.slice/docker-abc.scope/memory.usage_in_bytes": "104857600\n",
+				"memory/system.slice/docker-abc.scope/memory.limit_in_bytes": "9223372036854771712\n",
+				"memory/system.slice/docker-abc.scope/memory.stat":           "total_rss 104857600\n",
+			},
+			want: func() (s types.ContainerStatsRaw) {
+				s.MemoryStats.Usage = 104857600
+				s.MemoryStats.Limit = 4294967296
+				s.MemoryStats.Stats.RSS = 104857600
+				return
+			}(),
+		},
+		{
+			name: "cgroup v2 systemd driver",
+			cgroupFiles: map[string]string{
+				"cgroup.controllers":                           "cpu memory\n",
+				"system.slice/docker-abc.scope/cpu.stat":       "usage_usec 5000000\nuser_usec 4000000\n",
+				"system.slice/docker-abc.scope/memory.current": "104857600\n",
+				"system.slice/docker-abc.scope/memory.max":     "max\n",
+				"system.slice/docker-abc.scope/memory.stat":    "anon 83886080\nfile 20971520\ninactive_file 10485760\n",
+			},
+			want: func() (s types.ContainerStatsRaw) {
+				s.MemoryStats.Usage = 104857600
+				s.MemoryStats.Limit = 4294967296
+				s.MemoryStats.Stats.RSS = 83886080
+				s.MemoryStats.Stats.Cache = 20971520
+				s.MemoryStats.Stats.InactiveFile = 10485760
+				return
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAgent(t, tt.cgroupFiles)
+
+			got, err := a.ContainerStats(context.Background(), "abc")
+
+			if err != nil {
+				t.Fatalf("ContainerStats() error = %s", err)
+			}
+
+			if got.ID != "abc" {
+				t.Errorf("ID = %q, want abc", got.ID)
+			}
+
+			if got.CPUStats.CPUUsage.TotalUsage != 5000000000 || got.CPUStats.SystemCPUUsage != 10000000000 {
+				t.Errorf("CPUStats = %+v, want 5s of container time over 10s of system time", got.CPUStats)
+			}
+
+			if got.CPUStats.OnlineCPUs != 2 || got.PreCPUStats.OnlineCPUs != 2 {
+				t.Errorf("OnlineCPUs = %d/%d, want 2", got.PreCPUStats.OnlineCPUs, got.CPUStats.OnlineCPUs)
+			}
+
+			if got.MemoryStats != tt.want.MemoryStats {
+				t.Errorf("MemoryStats = %+v, want %+v", got.MemoryStats, tt.want.MemoryStats)
+			}
+		})
+	}
+}
+
+func TestContainerStatsUsesPreviousSample(t *testing.T) {
+	a := newTestAgent(t, map[string]string{
+		"cgroup.controllers":        "cpu memory\n",
+		"docker/abc/cpu.stat":       "usage_usec 1000000\n",
+		"docker/abc/memory.current": "1048576\n",
+		"docker/abc/memory.max":     "2097152\n",
+		"docker/abc/memory.stat":    "anon 1048576\n",
+	})
+
+	if _, err := a.ContainerStats(context.Background(), "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, a.cgroupRoot, map[string]string{"docker/abc/cpu.stat": "usage_usec 3000000\n"})
+
+	got, err := a.ContainerStats(context.Background(), "abc")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.PreCPUStats.CPUUsage.TotalUsage != 1000000000 || got.CPUStats.CPUUsage.TotalUsage != 3000000000 {
+		t.Errorf("cpu usage = %d -> %d, want 1000000000 -> 3000000000",
+			got.PreCPUStats.CPUUsage.TotalUsage, got.CPUStats.CPUUsage.TotalUsage)
+	}
+}
+
+func TestContainerStatsErrors(t *testing.T) {
+	a := newTestAgent(t, map[string]string{"cgroup.controllers": "cpu memory\n"})
+
+	for _, id := range []string{"missing", "../etc", ""} {
+		if _, err := a.ContainerStats(context.Background(), id); err == nil {
+			t.Errorf("ContainerStats(%q) error = nil, want an error", id)
+		}
+	}
+}
+
+func TestHostStats(t *testing.T) {
+	a := newTestAgent(t, nil)
+
+	if _, err := a.HostStats(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 200 more ticks, 100 of them idle
+	writeFiles(t, a.procRoot, map[string]string{
+		"stat": "cpu  150 0 150 750 150 0 0 0 0 0\ncpu0 75 0 75 375 75 0 0 0 0 0\ncpu1 75 0 75 375 75 0 0 0 0 0\n",
+	})
+
+	got, err := a.HostStats(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := types.HostStats{
+		Hostname:        a.hostname,
+		CPU:             50,
+		OnlineCPUs:      2,
+		Memory:          25,
+		MemoryTotal:     4294967296,
+		MemoryAvailable: 3221225472,
+		Load1:           0.5,
+		Load5:           0.25,
+		Load15:          0.1,
+	}
+
+	if got != want {
+		t.Errorf("HostStats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	a := newTestAgent(t, map[string]string{
+		"cgroup.controllers":        "cpu memory\n",
+		"docker/abc/cpu.stat":       "usage_usec 1000000\n",
+		"docker/abc/memory.current": "1048576\n",
+		"docker/abc/memory.max":     "2097152\n",
+		"docker/abc/memory.stat":    "anon 1048576\n",
+	})
+
+	server := httptest.NewServer(a.Handler())
+	defer server.Close()
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/containers/abc/stats", http.StatusOK},
+		{"/containers/missing/stats", http.StatusNotFound},
+		{"/containers/abc", http.StatusNotFound},
+		{"/host/stats", http.StatusOK},
+		{"/healthz", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		resp, err := http.Get(server.URL + tt.path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("GET %s = %d, want %d", tt.path, resp.StatusCode, tt.wantStatus)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/containers/abc/stats")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	var stats types.ContainerStatsRaw
+
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.MemoryStats.Usage != 1048576 || stats.MemoryStats.Limit != 2097152 {
+		t.Errorf("MemoryStats = %+v, want a usage of 1048576 and a limit of 2097152", stats.MemoryStats)
+	}
+}
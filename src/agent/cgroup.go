@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupStats is the cpu and memory accounting of the cgroup of a container
+type cgroupStats struct {
+	// CPUUsage is the cpu time consumed by the container in nanoseconds
+	CPUUsage    int64
+	MemoryUsage int64
+	// MemoryLimit is zero when the container has no memory limit
+	MemoryLimit       int64
+	RSS               int64
+	Cache             int64
+	TotalInactiveFile int64
+	InactiveFile      int64
+}
+
+// memoryLimitUnlimited is the smallest value cgroup v1 reports as the memory limit of an unlimited cgroup, the
+// largest page aligned int64
+const memoryLimitUnlimited = 0x7FFFFFFFFFFFF000
+
+// readCgroupStats reads the cgroup of a container under cgroupRoot, on both cgroup v1 and v2 hosts and with both the
+// cgroupfs and systemd cgroup drivers of the docker engine
+func readCgroupStats(cgroupRoot string, containerID string) (cgroupStats, error) {
+	if isCgroupV2(cgroupRoot) {
+		dir, err := findCgroupDir(cgroupRoot, containerID)
+
+		if err != nil {
+			return cgroupStats{}, err
+		}
+
+		return readCgroupV2Stats(dir)
+	}
+
+	cpuDir, err := findCgroupDir(filepath.Join(cgroupRoot, "cpuacct"), containerID)
+
+	if err != nil {
+		return cgroupStats{}, err
+	}
+
+	memoryDir, err := findCgroupDir(filepath.Join(cgroupRoot, "memory"), containerID)
+
+	if err != nil {
+		return cgroupStats{}, err
+	}
+
+	return readCgroupV1Stats(cpuDir, memoryDir)
+}
+
+// isCgroupV2 reports whether cgroupRoot is a cgroup v2 unified hierarchy
+func isCgroupV2(cgroupRoot string) bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+
+	return err == nil
+}
+
+// findCgroupDir returns the cgroup directory of a container under a hierarchy
+func findCgroupDir(hierarchy string, containerID string) (string, error) {
+	candidates := []string{
+		// cgroupfs driver
+		filepath.Join(hierarchy, "docker", containerID),
+		// systemd driver
+		filepath.Join(hierarchy, "system.slice", "docker-"+containerID+".scope"),
+	}
+
+	for _, dir := range candidates {
+		if stat, err := os.Stat(dir); err == nil && stat.IsDir() {
+			return dir, nil
+		}
+	}
+
+	return "", fmt.Errorf("no cgroup found for container %s under %s", containerID, hierarchy)
+}
+
+// readCgroupV1Stats
+func readCgroupV1Stats(cpuDir string, memoryDir string) (cgroupStats, error) {
+	var result cgroupStats
+	var err error
+
+	if result.CPUUsage, err = readInt(filepath.Join(cpuDir, "cpuacct.usage")); err != nil {
+		return result, err
+	}
+
+	if result.MemoryUsage, err = readInt(filepath.Join(memoryDir, "memory.usage_in_bytes")); err != nil {
+		return result, err
+	}
+
+	if result.MemoryLimit, err = readInt(filepath.Join(memoryDir, "memory.limit_in_bytes")); err != nil {
+		return result, err
+	}
+
+	if result.MemoryLimit >= memoryLimitUnlimited {
+		result.MemoryLimit = 0
+	}
+
+	memoryStat, err := readKeyValues(filepath.Join(memoryDir, "memory.stat"))
+
+	if err != nil {
+		return result, err
+	}
+
+	result.RSS = memoryStat["total_rss"]
+	result.Cache = memoryStat["total_cache"]
+	result.TotalInactiveFile = memoryStat["total_inactive_file"]
+
+	return result, nil
+}
+
+// readCgroupV2Stats
+func readCgroupV2Stats(dir string) (cgroupStats, error) {
+	var result cgroupStats
+
+	cpuStat, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+
+	if err != nil {
+		return result, err
+	}
+
+	usage, ok := cpuStat["usage_usec"]
+
+	if !ok {
+		return result, fmt.Errorf("no usage_usec in %s/cpu.stat", dir)
+	}
+
+	result.CPUUsage = usage * 1000
+
+	if result.MemoryUsage, err = readInt(filepath.Join(dir, "memory.current")); err != nil {
+		return result, err
+	}
+
+	memoryMax, err := ioutil.ReadFile(filepath.Join(dir, "memory.max"))
+
+	if err != nil {
+		return result, err
+	}
+
+	if value := strings.TrimSpace(string(memoryMax)); value != "max" {
+		if result.MemoryLimit, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return result, fmt.Errorf("invalid %s/memory.max: %q", dir, value)
+		}
+	}
+
+	memoryStat, err := readKeyValues(filepath.Join(dir, "memory.stat"))
+
+	if err != nil {
+		return result, err
+	}
+
+	result.RSS = memoryStat["anon"]
+	result.Cache = memoryStat["file"]
+	result.InactiveFile = memoryStat["inactive_file"]
+
+	return result, nil
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// clockTicksPerSecond is the USER_HZ unit of /proc/stat, which is 100 on every architecture docker supports
+const clockTicksPerSecond = 100
+
+// systemCPU is the cpu time spent by the host, in nanoseconds, computed the way the docker engine does for the
+// system_cpu_usage field of container stats
+type systemCPU struct {
+	Usage int64
+	// Idle is the part of Usage the cpus spent idle or waiting for io
+	Idle       int64
+	OnlineCPUs int64
+}
+
+// readSystemCPU
+func readSystemCPU(procRoot string) (systemCPU, error) {
+	var result systemCPU
+
+	data, err := ioutil.ReadFile(filepath.Join(procRoot, "stat"))
+
+	if err != nil {
+		return result, err
+	}
+
+	found := false
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+
+		if fields[0] != "cpu" {
+			result.OnlineCPUs++
+
+			continue
+		}
+
+		// user, nice, system, idle, iowait, irq, softirq and steal, the guest times are already part of user
+		if len(fields) < 9 {
+			return result, fmt.Errorf("invalid cpu line in %s/stat: %q", procRoot, line)
+		}
+
+		ticks := make([]int64, 8)
+		for i := range ticks {
+			if ticks[i], err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+				return result, fmt.Errorf("invalid cpu line in %s/stat: %q", procRoot, line)
+			}
+
+			result.Usage += ticks[i] * (1e9 / clockTicksPerSecond)
+		}
+
+		result.Idle = (ticks[3] + ticks[4]) * (1e9 / clockTicksPerSecond)
+		found = true
+	}
+
+	if !found {
+		return result, fmt.Errorf("no cpu line in %s/stat", procRoot)
+	}
+
+	return result, nil
+}
+
+// readMemInfo returns the total and available memory of the host in bytes
+func readMemInfo(procRoot string) (total int64, available int64, err error) {
+	values, err := readKeyValues(filepath.Join(procRoot, "meminfo"))
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	total, ok := values["MemTotal:"]
+
+	if !ok {
+		return 0, 0, fmt.Errorf("no MemTotal in %s/meminfo", procRoot)
+	}
+
+	available, ok = values["MemAvailable:"]
+
+	if !ok {
+		// kernels older than 3.14 do not report MemAvailable
+		available = values["MemFree:"] + values["Buffers:"] + values["Cached:"]
+	}
+
+	// meminfo is expressed in kB
+	return total * 1024, available * 1024, nil
+}
+
+// readLoadAvg returns the 1, 5 and 15 minutes load averages of the host
+func readLoadAvg(procRoot string) (load1 float64, load5 float64, load15 float64, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(procRoot, "loadavg"))
+
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	fields := strings.Fields(string(data))
+
+	if len(fields) < 3 {
+		return 0, 0, 0, fmt.Errorf("invalid %s/loadavg: %q", procRoot, string(data))
+	}
+
+	loads := make([]float64, 3)
+	for i := range loads {
+		if loads[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid %s/loadavg: %q", procRoot, string(data))
+		}
+	}
+
+	return loads[0], loads[1], loads[2], nil
+}
+
+// readKeyValues parses a file made of "key value" lines, like meminfo or the memory.stat file of a cgroup, ignoring
+// the lines whose value is not an integer
+func readKeyValues(path string) (map[string]int64, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]int64{}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+
+		if len(fields) < 2 {
+			continue
+		}
+
+		if v, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+
+	return values, nil
+}
+
+// readInt reads a file holding a single integer, like the cgroup memory.current file
+func readInt(path string) (int64, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
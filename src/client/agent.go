@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"../types"
+)
+
+// AgentClient talks to the http api of the agent running on a node
+type AgentClient struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+// NewAgentClient creates a client for the agent at endpoint, e.g. http://10.0.0.1:9324, using the certificates of
+// tlsConfig if any is set
+func NewAgentClient(endpoint string, tlsConfig types.TLSConfig) (*AgentClient, error) {
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid agent endpoint %q: %s", endpoint, err)
+	}
+
+	httpClient, err := newHTTPClient(tlsConfig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if httpClient == nil {
+		httpClient = &http.Client{Transport: &http.Transport{}}
+	}
+
+	return &AgentClient{
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		httpClient: httpClient,
+	}, nil
+}
+
+// GetContainerStats retrieves the stats of a container running on the node of the agent
+func (c *AgentClient) GetContainerStats(ctx context.Context, containerID string) (types.ContainerStatsRaw, error) {
+	var result types.ContainerStatsRaw
+
+	err := c.get(ctx, "/containers/"+url.PathEscape(containerID)+"/stats", &result)
+
+	return result, err
+}
+
+// GetHostStats retrieves the resource usage of the node of the agent
+func (c *AgentClient) GetHostStats(ctx context.Context) (types.HostStats, error) {
+	var result types.HostStats
+
+	err := c.get(ctx, "/host/stats", &result)
+
+	return result, err
+}
+
+// Close releases the idle connections to the agent
+func (c *AgentClient) Close() error {
+	if t, ok := c.httpClient.Transport.(*http.Transport); ok {
+		t.CloseIdleConnections()
+	}
+
+	return nil
+}
+
+// get
+func (c *AgentClient) get(ctx context.Context, path string, result interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint+path, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("agent %s returned %s: %s", c.endpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -4,33 +4,156 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"../types"
-	"../utils"
 	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
 	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
 )
 
-var ctx context.Context
-var cli *dockerClient.Client
+// SwarmAPI is the subset of the docker engine api used to inspect and scale a swarm cluster
+//
+// It is implemented by the docker client and by the in-memory swarm of the fakeswarm package
+type SwarmAPI interface {
+	SwarmInspect(ctx context.Context) (swarm.Swarm, error)
+	// ServiceListWithRaw also returns the raw json of the services, which holds the settings the vendored api types do
+	// not know about
+	ServiceListWithRaw(ctx context.Context) ([]swarm.Service, []byte, error)
+	ServiceInspectWithRaw(ctx context.Context, serviceID string) (swarm.Service, []byte, error)
+	// ServiceUpdateRaw takes the raw json of the spec rather than a swarm.ServiceSpec, so that updating a service keeps
+	// the settings the vendored api types do not know about
+	ServiceUpdateRaw(ctx context.Context, serviceID string, version swarm.Version, spec json.RawMessage) error
+	NodeList(ctx context.Context, options dockerTypes.NodeListOptions) ([]swarm.Node, error)
+	NodeInspectWithRaw(ctx context.Context, nodeID string) (swarm.Node, []byte, error)
+	NodeUpdate(ctx context.Context, nodeID string, version swarm.Version, node swarm.NodeSpec) error
+	TaskList(ctx context.Context, options dockerTypes.TaskListOptions) ([]swarm.Task, error)
+	ContainerStats(ctx context.Context, containerID string, stream bool) (dockerTypes.ContainerStats, error)
+}
+
+// Client translates the objects of a swarm cluster, as returned by a SwarmAPI, to the autoscaler types
+type Client struct {
+	api SwarmAPI
+}
+
+// New creates a Client on top of api
+func New(api SwarmAPI) *Client {
+	return &Client{api: api}
+}
 
-func init() {
-	ctx = context.Background()
+// NewDockerAPI creates a docker client talking to dockerHost or, if it is empty, to the host configured through the
+// standard DOCKER_* environment variables
+func NewDockerAPI(dockerHost string) (SwarmAPI, error) {
+	if dockerHost == "" {
+		dockerHost = os.Getenv("DOCKER_HOST")
+	}
 
-	cliTmp, err := dockerClient.NewEnvClient()
+	if dockerHost == "" {
+		dockerHost = dockerClient.DefaultDockerHost
+	}
 
-	if cliTmp == nil {
-		panic("could not get a new env client for docker")
+	version := os.Getenv("DOCKER_API_VERSION")
+	if version == "" {
+		version = dockerClient.DefaultVersion
 	}
 
-	utils.PanicOnError(err)
+	var tlsConfig types.TLSConfig
+
+	if certPath := os.Getenv("DOCKER_CERT_PATH"); certPath != "" {
+		tlsConfig = types.TLSConfig{
+			CA:                 filepath.Join(certPath, "ca.pem"),
+			Cert:               filepath.Join(certPath, "cert.pem"),
+			Key:                filepath.Join(certPath, "key.pem"),
+			InsecureSkipVerify: os.Getenv("DOCKER_TLS_VERIFY") == "",
+		}
+	}
 
-	cli = cliTmp
+	api, err := newDockerAPI(dockerHost, version, tlsConfig)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get a new env client for docker: %s", err)
+	}
+
+	return api, nil
+}
+
+// NewRemoteDockerAPI creates a docker client talking to the docker engine at host, using the certificates of
+// tlsConfig if any is set
+func NewRemoteDockerAPI(host string, tlsConfig types.TLSConfig) (SwarmAPI, error) {
+	api, err := newDockerAPI(host, dockerClient.DefaultVersion, tlsConfig)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get a new client for docker engine %s: %s", host, err)
+	}
+
+	return api, nil
+}
+
+// CheckTLSConfig verifies that the certificates of tlsConfig can be loaded
+func CheckTLSConfig(tlsConfig types.TLSConfig) error {
+	_, err := newHTTPClient(tlsConfig)
+
+	return err
+}
+
+// newHTTPClient returns an http client using the certificates of tlsConfig, or nil to let the docker client set up
+// a plain one if none is set
+func newHTTPClient(tlsConfig types.TLSConfig) (*http.Client, error) {
+	if !tlsConfig.Enabled() {
+		return nil, nil
+	}
+
+	tlsc, err := tlsconfig.Client(tlsconfig.Options{
+		CAFile:             tlsConfig.CA,
+		CertFile:           tlsConfig.Cert,
+		KeyFile:            tlsConfig.Key,
+		InsecureSkipVerify: tlsConfig.InsecureSkipVerify,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsc,
+		},
+	}, nil
+}
+
+// Close releases the connections of the underlying SwarmAPI, if it holds any
+func (c *Client) Close() error {
+	if closer, ok := c.api.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// CheckSwarm verifies that the docker engine is a manager of a swarm cluster
+func (c *Client) CheckSwarm(ctx context.Context) error {
+	if _, err := c.api.SwarmInspect(ctx); err != nil {
+		return fmt.Errorf("the docker engine is not a swarm manager: %s", err)
+	}
+
+	return nil
 }
 
 // GetServices gets a list of running services in the docker swarm cluster
-func GetServices() ([]types.Service, error) {
-	dockerServices, err := cli.ServiceList(ctx, dockerTypes.ServiceListOptions{})
+func (c *Client) GetServices(ctx context.Context) ([]types.Service, error) {
+	dockerServices, raw, err := c.api.ServiceListWithRaw(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	placements, err := parsePlacements(raw)
 
 	if err != nil {
 		return nil, err
@@ -40,45 +163,123 @@ func GetServices() ([]types.Service, error) {
 	for i := 0; i < len(dockerServices); i++ {
 		s := dockerServices[i]
 		services[i] = types.Service{
-			ID:   s.ID,
-			Name: s.Spec.Name,
+			ID:     s.ID,
+			Name:   s.Spec.Name,
+			Labels: s.Spec.Labels,
+		}
+
+		if resources := s.Spec.TaskTemplate.Resources; resources != nil {
+			if resources.Limits != nil {
+				services[i].NanoCPUsLimit = resources.Limits.NanoCPUs
+			}
+
+			services[i].Reservations = reservations(resources)
+		}
+
+		if placement := s.Spec.TaskTemplate.Placement; placement != nil {
+			services[i].Constraints = placement.Constraints
+		}
+
+		placement := placements[s.ID]
+		services[i].Platforms = placement.Platforms
+
+		for _, p := range placement.Preferences {
+			if p.Spread != nil {
+				services[i].Preferences = append(services[i].Preferences, p.Spread.SpreadDescriptor)
+			}
+		}
+
+		if replicated := s.Spec.Mode.Replicated; replicated != nil {
+			services[i].Mode = types.ServiceModeReplicated
+
+			if replicated.Replicas != nil {
+				services[i].Replicas = *replicated.Replicas
+			}
+		} else if s.Spec.Mode.Global != nil {
+			services[i].Mode = types.ServiceModeGlobal
 		}
 	}
 
 	return services, nil
 }
 
-// GetRunningActiveNodes gets a list of nodes in the docker swarm cluster
-func GetRunningActiveNodes() ([]types.Node, error) {
-	dockerNodes, err := cli.NodeList(ctx, dockerTypes.NodeListOptions{})
+// rawPlacement holds the placement settings of a service spec added to docker after the version the vendored api types
+// come from
+type rawPlacement struct {
+	Platforms   []types.Platform
+	Preferences []struct {
+		Spread *struct {
+			SpreadDescriptor string
+		}
+	}
+}
+
+// parsePlacements reads the placement settings the vendored api types drop from the raw json of a list of services, by
+// service ID
+func parsePlacements(raw []byte) (map[string]rawPlacement, error) {
+	var services []struct {
+		ID   string
+		Spec struct {
+			TaskTemplate struct {
+				Placement rawPlacement
+			}
+		}
+	}
+
+	if err := json.Unmarshal(raw, &services); err != nil {
+		return nil, fmt.Errorf("cannot parse the placement of the services: %s", err)
+	}
+
+	placements := map[string]rawPlacement{}
+	for _, s := range services {
+		placements[s.ID] = s.Spec.TaskTemplate.Placement
+	}
+
+	return placements, nil
+}
+
+// GetRunningActiveNodes gets the list of ready and active nodes in the docker swarm cluster
+func (c *Client) GetRunningActiveNodes(ctx context.Context) ([]types.Node, error) {
+	dockerNodes, err := c.api.NodeList(ctx, dockerTypes.NodeListOptions{})
 
 	if err != nil {
 		return nil, err
 	}
 
-	nodes := make([]types.Node, len(dockerNodes))
-	for i := 0; i < len(dockerNodes); i++ {
-		n := dockerNodes[i]
-
-		if n.Status.State != "ready" || n.Spec.Availability != "active" {
+	nodes := []types.Node{}
+	for _, n := range dockerNodes {
+		if n.Status.State != swarm.NodeStateReady || n.Spec.Availability != swarm.NodeAvailabilityActive {
 			continue
 		}
 
-		nodes[i] = types.Node{
-			ID:       n.ID,
-			IP:       n.Status.Addr,
-			Hostname: n.Description.Hostname,
-			Role:     string(n.Spec.Role),
-			Leader:   n.ManagerStatus != nil && n.ManagerStatus.Leader,
-		}
+		nodes = append(nodes, types.Node{
+			ID:            n.ID,
+			IP:            n.Status.Addr,
+			Hostname:      n.Description.Hostname,
+			Role:          string(n.Spec.Role),
+			Leader:        n.ManagerStatus != nil && n.ManagerStatus.Leader,
+			Availability:  string(n.Spec.Availability),
+			State:         string(n.Status.State),
+			Labels:        n.Spec.Labels,
+			EngineLabels:  n.Description.Engine.Labels,
+			EngineVersion: n.Description.Engine.EngineVersion,
+			Platform: types.Platform{
+				OS:           n.Description.Platform.OS,
+				Architecture: n.Description.Platform.Architecture,
+			},
+			Resources: types.NodeResources{
+				NanoCPUs:    n.Description.Resources.NanoCPUs,
+				MemoryBytes: n.Description.Resources.MemoryBytes,
+			},
+		})
 	}
 
 	return nodes, nil
 }
 
 // GetRunningTasks gets a list of tasks in the docker swarm cluster
-func GetRunningTasks() ([]types.RunningTask, error) {
-	dockerTasks, err := cli.TaskList(ctx, dockerTypes.TaskListOptions{})
+func (c *Client) GetRunningTasks(ctx context.Context) ([]types.RunningTask, error) {
+	dockerTasks, err := c.api.TaskList(ctx, dockerTypes.TaskListOptions{})
 
 	if err != nil {
 		return nil, err
@@ -96,6 +297,11 @@ func GetRunningTasks() ([]types.RunningTask, error) {
 			NodeID:      t.NodeID,
 			ServiceID:   t.ServiceID,
 			ContainerID: t.Status.ContainerStatus.ContainerID,
+			CreatedAt:   t.CreatedAt,
+		}
+
+		if t.Spec.Resources != nil {
+			tasks[cnt-1].Reservations = reservations(t.Spec.Resources)
 		}
 		cnt--
 	}
@@ -106,10 +312,10 @@ func GetRunningTasks() ([]types.RunningTask, error) {
 }
 
 // GetContainerStats retrieves usages statistics for a particular node in a swarm cluster
-func GetContainerStats(containerID string) (types.ContainerStatsRaw, error) {
+func (c *Client) GetContainerStats(ctx context.Context, containerID string) (types.ContainerStatsRaw, error) {
 	var result types.ContainerStatsRaw
 
-	stats, err := cli.ContainerStats(ctx, containerID, false)
+	stats, err := c.api.ContainerStats(ctx, containerID, false)
 
 	if err != nil {
 		return result, err
@@ -131,17 +337,11 @@ func GetContainerStats(containerID string) (types.ContainerStatsRaw, error) {
 }
 
 // AddLabelToNode adds a label to a swarm cluster node
-func AddLabelToNode(nodeID string, label string, value string) {
-	s, err := cli.SwarmInspect(ctx)
+func (c *Client) AddLabelToNode(ctx context.Context, nodeID string, label string, value string) error {
+	n, _, err := c.api.NodeInspectWithRaw(ctx, nodeID)
 
 	if err != nil {
-		return
-	}
-
-	n, _, err := cli.NodeInspectWithRaw(ctx, nodeID)
-
-	if err != nil {
-		return
+		return err
 	}
 
 	if n.Spec.Labels == nil {
@@ -150,24 +350,178 @@ func AddLabelToNode(nodeID string, label string, value string) {
 
 	n.Spec.Labels[label] = value
 
-	cli.NodeUpdate(ctx, nodeID, s.Version, n.Spec)
+	return c.api.NodeUpdate(ctx, nodeID, n.Version, n.Spec)
 }
 
 // RemoveLabelFromNode removes a label from a swarm cluster node
-func RemoveLabelFromNode(nodeID string, label string) {
-	s, err := cli.SwarmInspect(ctx)
+func (c *Client) RemoveLabelFromNode(ctx context.Context, nodeID string, label string) error {
+	n, _, err := c.api.NodeInspectWithRaw(ctx, nodeID)
 
 	if err != nil {
-		return
+		return err
 	}
 
-	n, _, err := cli.NodeInspectWithRaw(ctx, nodeID)
-
-	if err != nil || n.Spec.Labels == nil {
-		return
+	if _, ok := n.Spec.Labels[label]; !ok {
+		return nil
 	}
 
 	delete(n.Spec.Labels, label)
 
-	cli.NodeUpdate(ctx, nodeID, s.Version, n.Spec)
+	return c.api.NodeUpdate(ctx, nodeID, n.Version, n.Spec)
+}
+
+// SetServiceReplicas updates the replica count of a replicated mode service
+func (c *Client) SetServiceReplicas(ctx context.Context, serviceID string, replicas uint64) error {
+	return c.updateServiceSpec(ctx, serviceID, func(spec rawObject) error {
+		var mode, replicated rawObject
+
+		if _, err := spec.get("Mode", &mode); err != nil {
+			return err
+		}
+
+		ok, err := mode.get("Replicated", &replicated)
+
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			var name string
+			spec.get("Name", &name)
+
+			return fmt.Errorf("service %s is not in replicated mode", name)
+		}
+
+		if err := replicated.set("Replicas", replicas); err != nil {
+			return err
+		}
+
+		if err := mode.set("Replicated", replicated); err != nil {
+			return err
+		}
+
+		return spec.set("Mode", mode)
+	})
+}
+
+// SetServiceLabel sets a label of a service, or removes it if value is empty
+//
+// Only the labels of the service change, not the ones of its tasks, so its tasks are not restarted
+func (c *Client) SetServiceLabel(ctx context.Context, serviceID string, label string, value string) error {
+	return c.updateServiceSpec(ctx, serviceID, func(spec rawObject) error {
+		labels := map[string]string{}
+
+		if _, err := spec.get("Labels", &labels); err != nil {
+			return err
+		}
+
+		if value == "" {
+			delete(labels, label)
+		} else {
+			labels[label] = value
+		}
+
+		return spec.set("Labels", labels)
+	})
+}
+
+// updateServiceSpec applies change to the raw json of the spec of a service and updates it
+//
+// The spec never goes through swarm.ServiceSpec, which would drop the settings added to docker after the version the
+// vendored api types come from. The service is inspected again and the update retried if it was modified concurrently
+func (c *Client) updateServiceSpec(ctx context.Context, serviceID string, change func(spec rawObject) error) error {
+	var err error
+
+	for attempt := 0; attempt < serviceUpdateAttempts; attempt++ {
+		var s swarm.Service
+		var raw []byte
+
+		s, raw, err = c.api.ServiceInspectWithRaw(ctx, serviceID)
+
+		if err != nil {
+			return err
+		}
+
+		var service struct {
+			Spec rawObject
+		}
+
+		if err := json.Unmarshal(raw, &service); err != nil {
+			return fmt.Errorf("cannot parse the spec of service %s: %s", serviceID, err)
+		}
+
+		if service.Spec == nil {
+			service.Spec = rawObject{}
+		}
+
+		if err := change(service.Spec); err != nil {
+			return err
+		}
+
+		spec, err := json.Marshal(service.Spec)
+
+		if err != nil {
+			return err
+		}
+
+		err = c.api.ServiceUpdateRaw(ctx, serviceID, s.Version, spec)
+
+		if err == nil || !isOutOfSequence(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// rawObject is a json object whose fields are kept as raw json, so that changing some of them leaves the others
+// exactly as they were
+type rawObject map[string]json.RawMessage
+
+// get decodes a field into value and reports whether it is set
+func (o rawObject) get(field string, value interface{}) (bool, error) {
+	data, ok := o[field]
+
+	if !ok || string(data) == "null" {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(data, value); err != nil {
+		return false, fmt.Errorf("cannot parse %s: %s", field, err)
+	}
+
+	return true, nil
+}
+
+// set
+func (o rawObject) set(field string, value interface{}) error {
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		return err
+	}
+
+	o[field] = data
+
+	return nil
+}
+
+// serviceUpdateAttempts is the number of times a service update is attempted when it conflicts with another update
+const serviceUpdateAttempts = 3
+
+// isOutOfSequence reports whether err was caused by updating an object using an outdated version
+func isOutOfSequence(err error) bool {
+	return strings.Contains(err.Error(), "update out of sequence")
+}
+
+// reservations
+func reservations(resources *swarm.ResourceRequirements) types.NodeResources {
+	if resources.Reservations == nil {
+		return types.NodeResources{}
+	}
+
+	return types.NodeResources{
+		NanoCPUs:    resources.Reservations.NanoCPUs,
+		MemoryBytes: resources.Reservations.MemoryBytes,
+	}
 }
@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+
+	"../fakeswarm"
+	"../types"
+)
+
+func TestGetRunningActiveNodes(t *testing.T) {
+	f := fakeswarm.New()
+
+	manager := f.AddNode("manager-1", swarm.NodeRoleManager, map[string]string{"zone": "a"})
+	worker := f.AddNode("worker-1", swarm.NodeRoleWorker, nil)
+
+	f.SetNodeState(f.AddNode("worker-down", swarm.NodeRoleWorker, nil), swarm.NodeStateDown)
+	f.SetNodeState(f.AddNode("worker-disconnected", swarm.NodeRoleWorker, nil), swarm.NodeStateDisconnected)
+	f.SetNodeState(f.AddNode("worker-unknown", swarm.NodeRoleWorker, nil), swarm.NodeStateUnknown)
+	f.SetNodeAvailability(f.AddNode("worker-drained", swarm.NodeRoleWorker, nil), swarm.NodeAvailabilityDrain)
+	f.SetNodeAvailability(f.AddNode("worker-paused", swarm.NodeRoleWorker, nil), swarm.NodeAvailabilityPause)
+
+	nodes, err := New(f).GetRunningActiveNodes(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resources := types.NodeResources{NanoCPUs: 2e9, MemoryBytes: 4 << 30}
+	platform := types.Platform{OS: "linux", Architecture: "x86_64"}
+
+	want := []types.Node{
+		{
+			ID:            manager,
+			IP:            "10.0.0.1",
+			Hostname:      "manager-1",
+			Role:          "manager",
+			Leader:        true,
+			Availability:  "active",
+			State:         "ready",
+			Labels:        map[string]string{"zone": "a"},
+			EngineVersion: "17.06.0-ce",
+			Platform:      platform,
+			Resources:     resources,
+		},
+		{
+			ID:            worker,
+			IP:            "10.0.0.2",
+			Hostname:      "worker-1",
+			Role:          "worker",
+			Availability:  "active",
+			State:         "ready",
+			Labels:        map[string]string{},
+			EngineVersion: "17.06.0-ce",
+			Platform:      platform,
+			Resources:     resources,
+		},
+	}
+
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("got nodes %+v, want %+v", nodes, want)
+	}
+}
+
+func TestGetRunningActiveNodesFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := New(fakeswarm.New()).GetRunningActiveNodes(ctx); err == nil {
+		t.Error("got no error, want the one of the failing node list")
+	}
+}
+
+// newerSpec is the spec of a service using settings added to docker after the version the vendored api types come from,
+// like configs, placement preferences and rollback configs
+const newerSpec = `{
+	"Name": "web",
+	"Labels": {"team": "front"},
+	"TaskTemplate": {
+		"ContainerSpec": {
+			"Image": "nginx:alpine",
+			"Init": true,
+			"Configs": [
+				{"File": {"Name": "/etc/nginx/nginx.conf", "Mode": 292}, "ConfigID": "config-1", "ConfigName": "nginx"}
+			]
+		},
+		"Placement": {
+			"Constraints": ["node.role == worker"],
+			"Preferences": [{"Spread": {"SpreadDescriptor": "node.labels.zone"}}],
+			"MaxReplicas": 2
+		}
+	},
+	"Mode": {"Replicated": {"Replicas": 2}},
+	"RollbackConfig": {"Parallelism": 1, "Order": "start-first"}
+}`
+
+// rawSpec returns the json of the spec of a service as the swarm keeps it, decoded without any api type
+func rawSpec(t *testing.T, f *fakeswarm.Swarm, serviceID string) map[string]interface{} {
+	_, raw, err := f.ServiceInspectWithRaw(context.Background(), serviceID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var service struct {
+		Spec map[string]interface{}
+	}
+
+	if err := json.Unmarshal(raw, &service); err != nil {
+		t.Fatal(err)
+	}
+
+	return service.Spec
+}
+
+// parseSpec
+func parseSpec(t *testing.T, spec string) map[string]interface{} {
+	var result map[string]interface{}
+
+	if err := json.Unmarshal([]byte(spec), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestGetServicesPlacement(t *testing.T) {
+	f := fakeswarm.New()
+	web := f.AddRawService(`{
+		"Name": "web",
+		"TaskTemplate": {
+			"Placement": {
+				"Constraints": ["node.role == worker"],
+				"Preferences": [
+					{"Spread": {"SpreadDescriptor": "node.labels.zone"}},
+					{"Spread": {"SpreadDescriptor": "engine.labels.rack"}}
+				],
+				"Platforms": [{"Architecture": "amd64", "OS": "linux"}, {"Architecture": "arm64", "OS": "linux"}]
+			}
+		},
+		"Mode": {"Global": {}}
+	}`)
+
+	services, err := New(f).GetServices(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the platforms and preferences the vendored api types do not know about are read from the raw spec
+	want := []types.Service{{
+		ID:          web,
+		Name:        "web",
+		Constraints: []string{"node.role == worker"},
+		Platforms:   []types.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}},
+		Preferences: []string{"node.labels.zone", "engine.labels.rack"},
+		Mode:        types.ServiceModeGlobal,
+	}}
+
+	if !reflect.DeepEqual(services, want) {
+		t.Errorf("got services %+v, want %+v", services, want)
+	}
+}
+
+func TestSetServiceReplicas(t *testing.T) {
+	f := fakeswarm.New()
+	c := New(f)
+	web := f.AddRawService(newerSpec)
+
+	if err := c.SetServiceReplicas(context.Background(), web, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the replica count changed, the settings unknown to the api types survived the update
+	want := parseSpec(t, newerSpec)
+	want["Mode"] = map[string]interface{}{"Replicated": map[string]interface{}{"Replicas": 5.0}}
+
+	if got := rawSpec(t, f, web); !reflect.DeepEqual(got, want) {
+		t.Errorf("got spec %v, want %v", got, want)
+	}
+
+	if svc, _ := f.Service(web); svc.Version.Index != 2 {
+		t.Errorf("got service version %d, want it updated once to 2", svc.Version.Index)
+	}
+
+	const globalSpec = `{"Name": "api", "Mode": {"Global": {}}}`
+
+	api := f.AddRawService(globalSpec)
+	err := c.SetServiceReplicas(context.Background(), api, 5)
+
+	if want := "service api is not in replicated mode"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+
+	if got, want := rawSpec(t, f, api), parseSpec(t, globalSpec); !reflect.DeepEqual(got, want) {
+		t.Errorf("got spec %v after the failed update, want it unchanged %v", got, want)
+	}
+}
+
+func TestSetServiceLabel(t *testing.T) {
+	f := fakeswarm.New()
+	c := New(f)
+	web := f.AddRawService(newerSpec)
+
+	if err := c.SetServiceLabel(context.Background(), web, "state", `{"history": {}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the label was added, the task template is unchanged so the tasks are not restarted
+	want := parseSpec(t, newerSpec)
+	want["Labels"] = map[string]interface{}{"team": "front", "state": `{"history": {}}`}
+
+	if got := rawSpec(t, f, web); !reflect.DeepEqual(got, want) {
+		t.Errorf("got spec %v, want %v", got, want)
+	}
+
+	if err := c.SetServiceLabel(context.Background(), web, "state", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := rawSpec(t, f, web), parseSpec(t, newerSpec); !reflect.DeepEqual(got, want) {
+		t.Errorf("got spec %v after removing the label, want %v", got, want)
+	}
+}
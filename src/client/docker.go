@@ -0,0 +1,154 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"../types"
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/go-connections/sockets"
+)
+
+// dockerAPI is the SwarmAPI of a docker engine
+//
+// It adds to the docker client the requests whose body must not go through the vendored api types, which only know
+// the settings of the docker version they come from and would drop any newer one
+type dockerAPI struct {
+	*dockerClient.Client
+
+	httpClient *http.Client
+	scheme     string
+	proto      string
+	addr       string
+	basePath   string
+}
+
+// newDockerAPI creates a docker client talking to the docker engine at host with the given api version, using the
+// certificates of tlsConfig if any is set
+func newDockerAPI(host string, version string, tlsConfig types.TLSConfig) (*dockerAPI, error) {
+	proto, addr, basePath, err := dockerClient.ParseHost(host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := newHTTPClient(tlsConfig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "https"
+
+	if httpClient == nil {
+		transport := &http.Transport{}
+
+		if err := sockets.ConfigureTransport(transport, proto, addr); err != nil {
+			return nil, err
+		}
+
+		httpClient = &http.Client{Transport: transport}
+		scheme = "http"
+	}
+
+	cli, err := dockerClient.NewClient(host, version, httpClient, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &dockerAPI{
+		Client:     cli,
+		httpClient: httpClient,
+		scheme:     scheme,
+		proto:      proto,
+		addr:       addr,
+		basePath:   basePath,
+	}, nil
+}
+
+// ServiceUpdateRaw replaces the spec of a service with spec, as is, if version is its current version
+func (d *dockerAPI) ServiceUpdateRaw(ctx context.Context, serviceID string, version swarm.Version,
+	spec json.RawMessage) error {
+	query := url.Values{}
+	query.Set("version", strconv.FormatUint(version.Index, 10))
+
+	_, err := d.do(ctx, http.MethodPost, "/services/"+url.PathEscape(serviceID)+"/update", query, spec)
+
+	return err
+}
+
+// ServiceListWithRaw returns all the services and their json representation, which holds the settings of their specs
+// the vendored api types do not know about
+func (d *dockerAPI) ServiceListWithRaw(ctx context.Context) ([]swarm.Service, []byte, error) {
+	raw, err := d.do(ctx, http.MethodGet, "/services", url.Values{}, nil)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var services []swarm.Service
+
+	if err := json.Unmarshal(raw, &services); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse the list of services: %s", err)
+	}
+
+	return services, raw, nil
+}
+
+// do sends a request with body to the api of the docker engine the way the docker client does and returns the body of
+// the response
+func (d *dockerAPI) do(ctx context.Context, method string, path string, query url.Values, body []byte) ([]byte,
+	error) {
+	if v := strings.TrimPrefix(d.ClientVersion(), "v"); v != "" {
+		path = "/v" + v + path
+	}
+
+	u := url.URL{Scheme: d.scheme, Host: d.addr, Path: d.basePath + path, RawQuery: query.Encode()}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// the host does not matter on a local socket but must be a valid name
+	if d.proto == "unix" || d.proto == "npipe" {
+		req.Host = "docker"
+	}
+
+	resp, err := d.httpClient.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the docker engine at %s: %s", d.addr, err)
+	}
+
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 400 {
+		return data, nil
+	}
+
+	var errorResponse dockerTypes.ErrorResponse
+
+	if err := json.Unmarshal(data, &errorResponse); err == nil && errorResponse.Message != "" {
+		return nil, fmt.Errorf("Error response from daemon: %s", errorResponse.Message)
+	}
+
+	return nil, fmt.Errorf("Error response from daemon: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+
+	"../types"
+)
+
+func TestServiceUpdateRaw(t *testing.T) {
+	const spec = `{"Name": "web", "TaskTemplate": {"Placement": {"Preferences": [{"Spread": {}}]}}}`
+
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{name: "updated", status: http.StatusOK, body: `{"Warnings": null}`},
+		{
+			name:    "out of sequence",
+			status:  http.StatusInternalServerError,
+			body:    `{"message": "rpc error: code = 2 desc = update out of sequence"}`,
+			wantErr: "Error response from daemon: rpc error: code = 2 desc = update out of sequence",
+		},
+		{
+			name:    "not a docker error",
+			status:  http.StatusBadGateway,
+			body:    "bad gateway\n",
+			wantErr: "Error response from daemon: 502 Bad Gateway: bad gateway",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotRequest string
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				gotRequest = r.Method + " " + r.URL.String() + " " + r.Header.Get("Content-Type") + " " + string(body)
+
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			api, err := newDockerAPI("tcp://"+strings.TrimPrefix(server.URL, "http://"), "1.25", types.TLSConfig{})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = api.ServiceUpdateRaw(context.Background(), "service-1", swarm.Version{Index: 42}, []byte(spec))
+
+			if (tt.wantErr == "" && err != nil) || (tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr)) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+
+			// the spec is sent exactly as it was given
+			if want := "POST /v1.25/services/service-1/update?version=42 application/json " + spec; gotRequest != want {
+				t.Errorf("got request %q, want %q", gotRequest, want)
+			}
+		})
+	}
+}
+
+func TestServiceListWithRaw(t *testing.T) {
+	const body = `[{"ID": "service-1", "Spec": {"Name": "web", "TaskTemplate": {"Placement": {"Platforms": [{}]}}}}]`
+
+	var gotRequest string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRequest = r.Method + " " + r.URL.String()
+
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	api, err := newDockerAPI("tcp://"+strings.TrimPrefix(server.URL, "http://"), "1.25", types.TLSConfig{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services, raw, err := api.ServiceListWithRaw(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "GET /v1.25/services"; gotRequest != want {
+		t.Errorf("got request %q, want %q", gotRequest, want)
+	}
+
+	if len(services) != 1 || services[0].ID != "service-1" || services[0].Spec.Name != "web" {
+		t.Errorf("got services %+v, want service-1 named web", services)
+	}
+
+	// the raw json is returned as is, with the settings the api types drop
+	if string(raw) != body {
+		t.Errorf("got raw json %s, want %s", raw, body)
+	}
+}
@@ -1,73 +1,302 @@
 package cluster
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
 	"../client"
+	"../collector"
+	"../events"
 	"../types"
 	"../utils"
 )
 
-var state types.ClusterState = types.NewClusterState()
+var (
+	state          atomic.Value
+	swarmClient    *client.Client
+	statsCollector *collector.Collector
+	// nodeStats holds the usage of the nodes reported by their agent, by node ID
+	nodeStats atomic.Value
+)
+
+// SetClient sets the client through which the package talks to the swarm cluster, fetching container stats with at
+// most statsWorkers concurrent requests
+func SetClient(c *client.Client, statsWorkers int) {
+	swarmClient = c
+	statsCollector = collector.New(fetchStats, statsWorkers)
+}
+
+func init() {
+	state.Store(types.NewClusterState())
+	nodeStats.Store(map[string]types.HostStats{})
+}
 
 // GetState returns the most recently updated swarm cluster state
+//
+// The returned snapshot is shared with every other caller and must not be modified
 func GetState() types.ClusterState {
-	return state
+	return state.Load().(types.ClusterState)
 }
 
-// GetRunningActiveNodes returns all the running and active nodes in the swarm cluster
-func GetRunningActiveNodes() []types.Node {
-	nodes, err := client.GetRunningActiveNodes()
+// GetContainerStats returns the stats of a container collected by the last CollectContainerStats call, or nil if
+// there are none
+//
+// If nanoCPUsLimit is not zero the cpu usage is expressed relative to it instead of to a single host cpu
+func GetContainerStats(containerID string, nanoCPUsLimit int64) *types.ContainerStats {
+	sample, ok := statsCollector.Latest(containerID)
 
-	if err != nil {
+	if !ok {
 		return nil
 	}
 
-	return nodes
+	return &types.ContainerStats{
+		Raw:       sample.Stats,
+		Usage:     utils.ExtractContainerResourceUsage(sample.Stats, nanoCPUsLimit),
+		Timestamp: sample.Timestamp,
+	}
 }
 
-// GetContainerStats uses the client package to retreive a container's usage statistics
-func GetContainerStats(containerID string) *types.ContainerStats {
-	stats, err := client.GetContainerStats(containerID)
+// SetClock replaces the clock that timestamps the collected stats, which tests use to simulate the passing of time
+func SetClock(now func() time.Time) {
+	statsCollector.SetClock(now)
+}
 
-	if err != nil {
-		return nil
+// GetNodeUsage returns the usage of a node as reported by its agent during the last CollectContainerStats call, or
+// estimated from the stats of the containers collected on it when its agent did not report it
+func GetNodeUsage(node types.Node) types.NodeUsage {
+	if s, ok := nodeStats.Load().(map[string]types.HostStats)[node.ID]; ok {
+		return types.NodeUsage{CPU: s.CPU, Memory: s.Memory}
 	}
 
-	return &types.ContainerStats{
-		Raw:   stats,
-		Usage: utils.ExtractContainerResourceUsage(stats),
+	usedCPUs := 0.0
+	usedMemory := 0.0
+
+	for _, t := range GetState().RunningTasks {
+		if t.NodeID != node.ID {
+			continue
+		}
+
+		sample, ok := statsCollector.Latest(t.ContainerID)
+
+		if !ok {
+			continue
+		}
+
+		usage := utils.ExtractContainerResourceUsage(sample.Stats, 0)
+		usedCPUs += usage.CPU / 100.0
+		usedMemory += usage.Memory / 100.0 * float64(sample.Stats.MemoryStats.Limit)
 	}
+
+	result := types.NodeUsage{Estimated: true}
+
+	if node.Resources.NanoCPUs > 0 {
+		result.CPU = usedCPUs / (float64(node.Resources.NanoCPUs) / 1e9) * 100.0
+	}
+
+	if node.Resources.MemoryBytes > 0 {
+		result.Memory = usedMemory / float64(node.Resources.MemoryBytes) * 100.0
+	}
+
+	return result
 }
 
-// UpdateState updates the cluster state snapshot kept in memory
-func UpdateState() {
-	tasks, err := client.GetRunningTasks()
-	utils.PanicOnError(err)
+// EstimatesNodeUsage reports whether GetNodeUsage estimates the usage of the nodes from the stats of the containers
+// collected on them, which only counts the containers that CollectContainerStats was given
+func EstimatesNodeUsage() bool {
+	source := hostStatsSource.Load().(*remoteStats)
 
-	for _, t := range tasks {
-		state.RunningTasks[t.ID] = t
+	return source == nil || !source.reportsHostStats()
+}
+
+// CollectContainerStats concurrently fetches the stats of the running tasks and forgets the stats of every other
+// container, then fetches the usage of the nodes if their agent reports it
+func CollectContainerStats(ctx context.Context, tasks []types.RunningTask) {
+	clusterState := GetState()
+
+	targets := make([]collector.Target, len(tasks))
+	for i, t := range tasks {
+		targets[i] = collector.Target{ContainerID: t.ContainerID, Node: clusterState.RunningActiveNodes[t.NodeID]}
 	}
 
-	services, err := client.GetServices()
-	utils.PanicOnError(err)
+	started := time.Now()
+	failures := statsCollector.Collect(ctx, targets)
 
-	for _, s := range services {
-		state.Services[s.ID] = s
+	log.WithField("elapsed", time.Since(started).String()).Debugf("collected the stats of %d containers",
+		len(targets)-failures)
+
+	if failures > 0 {
+		log.Warnf("cannot fetch the stats of %d out of %d containers", failures, len(targets))
+	}
+
+	collectNodeStats(ctx, clusterState)
+}
+
+// collectNodeStats fetches the usage of every running and active node from its agent, if the stats source is the
+// agent
+func collectNodeStats(ctx context.Context, clusterState types.ClusterState) {
+	stats := map[string]types.HostStats{}
+
+	if EstimatesNodeUsage() {
+		nodeStats.Store(stats)
+
+		return
+	}
+
+	source := hostStatsSource.Load().(*remoteStats)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, n := range clusterState.RunningActiveNodes {
+		wg.Add(1)
+
+		go func(n types.Node) {
+			defer wg.Done()
+
+			s, err := source.fetchHostStats(ctx, n)
+
+			if err != nil {
+				log.Warnf("cannot fetch the usage of node %s: %s", n.Hostname, err)
+
+				return
+			}
+
+			lock.Lock()
+			stats[n.ID] = s
+			lock.Unlock()
+		}(n)
+	}
+
+	wg.Wait()
+
+	nodeStats.Store(stats)
+}
+
+// UpdateState builds a new cluster state snapshot from exactly what docker reports and atomically replaces the one
+// kept in memory, emitting events for the services, nodes and tasks that appeared or disappeared in between
+//
+// The current snapshot stays in place if any of the docker calls fails
+func UpdateState(ctx context.Context) error {
+	tasks, err := swarmClient.GetRunningTasks(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	services, err := swarmClient.GetServices(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	nodes, err := swarmClient.GetRunningActiveNodes(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	oldState := GetState()
+	newState := types.NewClusterState()
+
+	for _, t := range tasks {
+		newState.RunningTasks[t.ID] = t
 	}
 
-	nodes, err := client.GetRunningActiveNodes()
-	utils.PanicOnError(err)
+	for _, s := range services {
+		newState.Services[s.ID] = s
+	}
 
 	for _, n := range nodes {
-		state.RunningActiveNodes[n.ID] = n
+		newState.RunningActiveNodes[n.ID] = n
+	}
+
+	newState.Generation = oldState.Generation + 1
+	newState.Timestamp = time.Now()
+
+	state.Store(newState)
+
+	// everything appears in the first snapshot so only its size is worth reporting
+	if oldState.Generation == 0 {
+		log.Infof("initial cluster state has %d services, %d running and active nodes and %d running tasks",
+			len(newState.Services), len(newState.RunningActiveNodes), len(newState.RunningTasks))
+
+		return nil
+	}
+
+	emitStateChanges(oldState, newState)
+
+	return nil
+}
+
+// emitStateChanges
+func emitStateChanges(oldState types.ClusterState, newState types.ClusterState) {
+	for id, s := range newState.Services {
+		if _, ok := oldState.Services[id]; !ok {
+			events.Emit(events.ServiceAppeared, log.Fields{"service_id": id, "service": s.Name})
+		}
+	}
+
+	for id, s := range oldState.Services {
+		if _, ok := newState.Services[id]; !ok {
+			events.Emit(events.ServiceDisappeared, log.Fields{"service_id": id, "service": s.Name})
+		}
+	}
+
+	for id, n := range newState.RunningActiveNodes {
+		if _, ok := oldState.RunningActiveNodes[id]; !ok {
+			events.Emit(events.NodeAppeared, log.Fields{"node_id": id, "hostname": n.Hostname})
+		}
+	}
+
+	for id, n := range oldState.RunningActiveNodes {
+		if _, ok := newState.RunningActiveNodes[id]; !ok {
+			events.Emit(events.NodeDisappeared, log.Fields{"node_id": id, "hostname": n.Hostname})
+		}
+	}
+
+	for id, t := range newState.RunningTasks {
+		if _, ok := oldState.RunningTasks[id]; !ok {
+			events.Emit(events.TaskAppeared, taskFields(newState, t))
+		}
+	}
+
+	for id, t := range oldState.RunningTasks {
+		if _, ok := newState.RunningTasks[id]; !ok {
+			events.Emit(events.TaskDisappeared, taskFields(oldState, t))
+		}
+	}
+}
+
+// taskFields
+func taskFields(clusterState types.ClusterState, t types.RunningTask) log.Fields {
+	return log.Fields{
+		"task_id":    t.ID,
+		"service_id": t.ServiceID,
+		"service":    clusterState.Services[t.ServiceID].Name,
+		"node_id":    t.NodeID,
 	}
 }
 
 // AddLabelToNode adds a label to a swarm cluster node
-func AddLabelToNode(nodeID string, label string, value string) {
-	client.AddLabelToNode(nodeID, label, value)
+func AddLabelToNode(ctx context.Context, nodeID string, label string, value string) error {
+	return swarmClient.AddLabelToNode(ctx, nodeID, label, value)
 }
 
 // RemoveLabelFromNode removes a label from a swarm cluster node
-func RemoveLabelFromNode(nodeID string, label string) {
-	client.RemoveLabelFromNode(nodeID, label)
+func RemoveLabelFromNode(ctx context.Context, nodeID string, label string) error {
+	return swarmClient.RemoveLabelFromNode(ctx, nodeID, label)
+}
+
+// SetServiceReplicas updates the replica count of a replicated mode service
+func SetServiceReplicas(ctx context.Context, serviceID string, replicas uint64) error {
+	return swarmClient.SetServiceReplicas(ctx, serviceID, replicas)
+}
+
+// SetServiceLabel sets a label of a service, or removes it if value is empty
+func SetServiceLabel(ctx context.Context, serviceID string, label string, value string) error {
+	return swarmClient.SetServiceLabel(ctx, serviceID, label, value)
 }
@@ -0,0 +1,338 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"../client"
+	"../fakeswarm"
+	"../types"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+
+	os.Exit(m.Run())
+}
+
+// useFakeSwarm points the package to a new fake swarm and forgets the current cluster state
+func useFakeSwarm() *fakeswarm.Swarm {
+	f := fakeswarm.New()
+
+	SetClient(client.New(f), 2)
+	state.Store(types.NewClusterState())
+
+	return f
+}
+
+// emittedEvents lists the events logged since the last call, as the event type followed by the IDs and names it is
+// about, in order
+func emittedEvents(hook *test.Hook) []string {
+	result := []string{}
+
+	for _, e := range hook.AllEntries() {
+		eventType, ok := e.Data["event"]
+
+		if !ok {
+			continue
+		}
+
+		event := fmt.Sprint(eventType)
+		for _, field := range []string{"service_id", "service", "node_id", "hostname", "task_id"} {
+			if value, ok := e.Data[field]; ok {
+				event += fmt.Sprintf(" %s=%s", field, value)
+			}
+		}
+
+		result = append(result, event)
+	}
+
+	hook.Reset()
+	sort.Strings(result)
+
+	return result
+}
+
+// ids lists the keys of a map of the cluster state in order
+func ids(m interface{}) []string {
+	result := []string{}
+
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		result = append(result, k.String())
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func TestUpdateState(t *testing.T) {
+	f := useFakeSwarm()
+	hook := test.NewGlobal()
+	ctx := context.Background()
+
+	f.AddNode("worker-1", swarm.NodeRoleWorker, nil)
+	f.AddNode("worker-2", swarm.NodeRoleWorker, nil)
+
+	// api runs task-4 on node-1 and task-5 on node-2, web runs task-7 on node-1
+	f.AddService(swarm.ServiceSpec{
+		Annotations: swarm.Annotations{Name: "api"},
+		Mode:        swarm.ServiceMode{Global: &swarm.GlobalService{}},
+	})
+
+	replicas := uint64(1)
+	web := f.AddService(swarm.ServiceSpec{
+		Annotations: swarm.Annotations{Name: "web"},
+		Mode:        swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+	})
+
+	if err := UpdateState(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	first := GetState()
+
+	if got := emittedEvents(hook); len(got) != 0 {
+		t.Errorf("got events %v for the first snapshot, want none", got)
+	}
+
+	// web and worker-2 go away along with their tasks, api starts task-9 on the new node-8
+	f.RemoveService(web)
+	f.SetNodeState("node-2", swarm.NodeStateDown)
+	f.AddNode("worker-3", swarm.NodeRoleWorker, nil)
+
+	if err := UpdateState(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	second := GetState()
+
+	if first.Generation != 1 || second.Generation != 2 {
+		t.Errorf("got generations %d and %d, want 1 and 2", first.Generation, second.Generation)
+	}
+
+	// the snapshots are replaced rather than modified, so the first one still holds what it saw
+	want := map[string][]string{
+		"first services":  {"service-3", "service-6"},
+		"first nodes":     {"node-1", "node-2"},
+		"first tasks":     {"task-4", "task-5", "task-7"},
+		"second services": {"service-3"},
+		"second nodes":    {"node-1", "node-8"},
+		"second tasks":    {"task-4", "task-9"},
+	}
+
+	got := map[string][]string{
+		"first services":  ids(first.Services),
+		"first nodes":     ids(first.RunningActiveNodes),
+		"first tasks":     ids(first.RunningTasks),
+		"second services": ids(second.Services),
+		"second nodes":    ids(second.RunningActiveNodes),
+		"second tasks":    ids(second.RunningTasks),
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got snapshots %v, want %v", got, want)
+	}
+
+	wantEvents := []string{
+		"node_appeared node_id=node-8 hostname=worker-3",
+		"node_disappeared node_id=node-2 hostname=worker-2",
+		"service_disappeared service_id=service-6 service=web",
+		"task_appeared service_id=service-3 service=api node_id=node-8 task_id=task-9",
+		"task_disappeared service_id=service-3 service=api node_id=node-2 task_id=task-5",
+		"task_disappeared service_id=service-6 service=web node_id=node-1 task_id=task-7",
+	}
+
+	if got := emittedEvents(hook); !reflect.DeepEqual(got, wantEvents) {
+		t.Errorf("got events %v, want %v", got, wantEvents)
+	}
+
+	// a new service is reported along with its tasks
+	f.AddService(swarm.ServiceSpec{
+		Annotations: swarm.Annotations{Name: "worker"},
+		Mode:        swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+	})
+
+	if err := UpdateState(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	wantEvents = []string{
+		"service_appeared service_id=service-10 service=worker",
+		"task_appeared service_id=service-10 service=worker node_id=node-1 task_id=task-11",
+	}
+
+	if got := emittedEvents(hook); !reflect.DeepEqual(got, wantEvents) {
+		t.Errorf("got events %v, want %v", got, wantEvents)
+	}
+}
+
+func TestUpdateStateFailure(t *testing.T) {
+	for _, method := range []string{"TaskList", "ServiceListWithRaw", "NodeList"} {
+		t.Run(method, func(t *testing.T) {
+			f := useFakeSwarm()
+			hook := test.NewGlobal()
+			ctx := context.Background()
+
+			f.AddNode("worker-1", swarm.NodeRoleWorker, nil)
+			f.AddService(swarm.ServiceSpec{
+				Annotations: swarm.Annotations{Name: "api"},
+				Mode:        swarm.ServiceMode{Global: &swarm.GlobalService{}},
+			})
+
+			if err := UpdateState(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			before := GetState()
+			emittedEvents(hook)
+
+			f.AddNode("worker-2", swarm.NodeRoleWorker, nil)
+			f.SetFailure(method, fmt.Errorf("connection refused"))
+
+			if err := UpdateState(ctx); err == nil {
+				t.Fatal("got no error, want the one of the failing docker call")
+			}
+
+			if after := GetState(); !reflect.DeepEqual(after, before) {
+				t.Errorf("got cluster state %+v after the failure, want the previous one %+v", after, before)
+			}
+
+			if got := emittedEvents(hook); len(got) != 0 {
+				t.Errorf("got events %v after the failure, want none", got)
+			}
+
+			// the changes missed by the failed update are reported by the next one
+			f.SetFailure(method, nil)
+
+			if err := UpdateState(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			wantEvents := []string{
+				"node_appeared node_id=node-4 hostname=worker-2",
+				"task_appeared service_id=service-2 service=api node_id=node-4 task_id=task-5",
+			}
+
+			if got := emittedEvents(hook); !reflect.DeepEqual(got, wantEvents) {
+				t.Errorf("got events %v, want %v", got, wantEvents)
+			}
+
+			if got := GetState().Generation; got != before.Generation+1 {
+				t.Errorf("got generation %d, want %d", got, before.Generation+1)
+			}
+		})
+	}
+}
+
+func TestGetNodeUsage(t *testing.T) {
+	f := useFakeSwarm()
+	ctx := context.Background()
+
+	f.AddNode("worker-1", swarm.NodeRoleWorker, nil)
+	f.AddNode("worker-2", swarm.NodeRoleWorker, nil)
+
+	// api runs on both nodes and web on worker-1 only, every container uses a share of 1 cpu and of a 1GiB limit
+	api := f.AddService(swarm.ServiceSpec{
+		Annotations: swarm.Annotations{Name: "api"},
+		Mode:        swarm.ServiceMode{Global: &swarm.GlobalService{}},
+	})
+	f.SetServiceUsage(api, fakeswarm.Usage{CPU: 50, Memory: 10})
+
+	web := f.AddService(swarm.ServiceSpec{
+		Annotations:  swarm.Annotations{Name: "web"},
+		TaskTemplate: swarm.TaskSpec{Placement: &swarm.Placement{Constraints: []string{"node.hostname == worker-1"}}},
+		Mode:         swarm.ServiceMode{Global: &swarm.GlobalService{}},
+	})
+	f.SetServiceUsage(web, fakeswarm.Usage{CPU: 30, Memory: 20})
+
+	if err := UpdateState(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	clusterState := GetState()
+
+	// tasks lists the running tasks of the given services
+	tasks := func(serviceIDs ...string) []types.RunningTask {
+		result := []types.RunningTask{}
+
+		for _, id := range ids(clusterState.RunningTasks) {
+			for _, serviceID := range serviceIDs {
+				if clusterState.RunningTasks[id].ServiceID == serviceID {
+					result = append(result, clusterState.RunningTasks[id])
+				}
+			}
+		}
+
+		return result
+	}
+
+	if !EstimatesNodeUsage() {
+		t.Fatal("got node usage reported by agents, want it estimated without a stats configuration")
+	}
+
+	// the nodes have 2 cpus and 4GiB of memory
+	steps := []struct {
+		name  string
+		tasks []types.RunningTask
+		want  map[string]types.NodeUsage
+	}{
+		{
+			name:  "every container collected",
+			tasks: tasks(api, web),
+			want: map[string]types.NodeUsage{
+				"node-1": {CPU: 40, Memory: 7.5, Estimated: true},
+				"node-2": {CPU: 25, Memory: 2.5, Estimated: true},
+			},
+		},
+		{
+			name:  "containers of web not collected",
+			tasks: tasks(api),
+			want: map[string]types.NodeUsage{
+				"node-1": {CPU: 25, Memory: 2.5, Estimated: true},
+				"node-2": {CPU: 25, Memory: 2.5, Estimated: true},
+			},
+		},
+		{
+			name:  "nothing collected",
+			tasks: nil,
+			want: map[string]types.NodeUsage{
+				"node-1": {Estimated: true},
+				"node-2": {Estimated: true},
+			},
+		},
+	}
+
+	for _, s := range steps {
+		CollectContainerStats(ctx, s.tasks)
+
+		for nodeID, want := range s.want {
+			got := GetNodeUsage(clusterState.RunningActiveNodes[nodeID])
+
+			if math.Abs(got.CPU-want.CPU) > 1e-6 || math.Abs(got.Memory-want.Memory) > 1e-6 ||
+				got.Estimated != want.Estimated {
+				t.Errorf("%s: got usage %+v of %s, want %+v", s.name, got, nodeID, want)
+			}
+		}
+	}
+
+	// the usage reported by the agent of a node replaces the estimate
+	nodeStats.Store(map[string]types.HostStats{"node-1": {CPU: 70, Memory: 60}})
+	defer nodeStats.Store(map[string]types.HostStats{})
+
+	want := types.NodeUsage{CPU: 70, Memory: 60}
+
+	if got := GetNodeUsage(clusterState.RunningActiveNodes["node-1"]); got != want {
+		t.Errorf("got usage %+v of node-1, want the one reported by its agent %+v", got, want)
+	}
+}
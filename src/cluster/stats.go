@@ -0,0 +1,219 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"../client"
+	"../collector"
+	"../types"
+)
+
+var (
+	// statsFetcher holds the collector.FetchFunc selected by the stats configuration
+	statsFetcher atomic.Value
+	// currentRemoteStats is the remote source in use, if any, whose connections are released when it is replaced
+	currentRemoteStats *remoteStats
+	// hostStatsSource holds currentRemoteStats for the readers outside of SetStatsConfig
+	hostStatsSource atomic.Value
+)
+
+func init() {
+	statsFetcher.Store(collector.FetchFunc(fetchLocalStats))
+	hostStatsSource.Store((*remoteStats)(nil))
+}
+
+// SetStatsConfig selects where the stats of the containers are fetched from
+//
+// The previous source is kept if the new one cannot be set up, e.g. because its certificates cannot be read
+func SetStatsConfig(statsConfig types.StatsConfig) error {
+	previousRemoteStats := currentRemoteStats
+
+	var newClient func(endpoint string, tlsConfig types.TLSConfig) (statsClient, error)
+
+	switch statsConfig.Source {
+	case "", types.StatsSourceLocal:
+	case types.StatsSourceEngine:
+		newClient = newEngineStatsClient
+	case types.StatsSourceAgent:
+		newClient = newAgentStatsClient
+	default:
+		return fmt.Errorf("unknown stats source %q", statsConfig.Source)
+	}
+
+	if newClient == nil {
+		statsFetcher.Store(collector.FetchFunc(fetchLocalStats))
+		currentRemoteStats = nil
+	} else {
+		// fail now rather than on every fetch if the certificates are unusable
+		if err := client.CheckTLSConfig(statsConfig.TLS); err != nil {
+			return fmt.Errorf("invalid stats tls configuration: %s", err)
+		}
+
+		currentRemoteStats = &remoteStats{config: statsConfig, newClient: newClient, clients: map[string]statsClient{}}
+		statsFetcher.Store(collector.FetchFunc(currentRemoteStats.fetch))
+	}
+
+	hostStatsSource.Store(currentRemoteStats)
+
+	if previousRemoteStats != nil {
+		previousRemoteStats.close()
+	}
+
+	return nil
+}
+
+// fetchStats fetches the stats of a container from the source selected by the stats configuration
+func fetchStats(ctx context.Context, target collector.Target) (types.ContainerStatsRaw, error) {
+	return statsFetcher.Load().(collector.FetchFunc)(ctx, target)
+}
+
+// fetchLocalStats fetches the stats of a container from the docker engine the package is connected to
+func fetchLocalStats(ctx context.Context, target collector.Target) (types.ContainerStatsRaw, error) {
+	return swarmClient.GetContainerStats(ctx, target.ContainerID)
+}
+
+// statsClient fetches the stats of the containers running on a single node
+type statsClient interface {
+	GetContainerStats(ctx context.Context, containerID string) (types.ContainerStatsRaw, error)
+	Close() error
+}
+
+// hostStatsClient is implemented by the stats clients that also report the usage of their whole node
+type hostStatsClient interface {
+	GetHostStats(ctx context.Context) (types.HostStats, error)
+}
+
+// newEngineStatsClient creates a client for the docker engine of a node
+func newEngineStatsClient(endpoint string, tlsConfig types.TLSConfig) (statsClient, error) {
+	api, err := client.NewRemoteDockerAPI(endpoint, tlsConfig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return client.New(api), nil
+}
+
+// newAgentStatsClient creates a client for the agent running on a node
+func newAgentStatsClient(endpoint string, tlsConfig types.TLSConfig) (statsClient, error) {
+	return client.NewAgentClient(endpoint, tlsConfig)
+}
+
+// remoteStats fetches the stats of every container from an endpoint on the node it runs on, either its docker engine
+// or its agent
+type remoteStats struct {
+	config    types.StatsConfig
+	newClient func(endpoint string, tlsConfig types.TLSConfig) (statsClient, error)
+
+	lock    sync.Mutex
+	clients map[string]statsClient
+}
+
+// fetch
+func (r *remoteStats) fetch(ctx context.Context, target collector.Target) (types.ContainerStatsRaw, error) {
+	endpoint, err := nodeStatsEndpoint(r.config, target.Node)
+
+	if err != nil {
+		return types.ContainerStatsRaw{}, err
+	}
+
+	c, err := r.client(endpoint)
+
+	if err != nil {
+		return types.ContainerStatsRaw{}, err
+	}
+
+	return c.GetContainerStats(ctx, target.ContainerID)
+}
+
+// reportsHostStats reports whether the endpoints of the nodes report the usage of the whole node
+func (r *remoteStats) reportsHostStats() bool {
+	return r.config.Source == types.StatsSourceAgent
+}
+
+// fetchHostStats
+func (r *remoteStats) fetchHostStats(ctx context.Context, node types.Node) (types.HostStats, error) {
+	endpoint, err := nodeStatsEndpoint(r.config, node)
+
+	if err != nil {
+		return types.HostStats{}, err
+	}
+
+	c, err := r.client(endpoint)
+
+	if err != nil {
+		return types.HostStats{}, err
+	}
+
+	hc, ok := c.(hostStatsClient)
+
+	if !ok {
+		return types.HostStats{}, fmt.Errorf("the %s stats source does not report node usage", r.config.Source)
+	}
+
+	return hc.GetHostStats(ctx)
+}
+
+// client returns the client of the endpoint, creating it on first use
+func (r *remoteStats) client(endpoint string) (statsClient, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if c, ok := r.clients[endpoint]; ok {
+		return c, nil
+	}
+
+	c, err := r.newClient(endpoint, r.config.TLS)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.clients[endpoint] = c
+
+	return c, nil
+}
+
+// close releases the connections to the endpoints of the nodes
+func (r *remoteStats) close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, c := range r.clients {
+		c.Close()
+	}
+
+	r.clients = map[string]statsClient{}
+}
+
+// nodeStatsEndpoint returns the address of the docker engine or agent of a node, preferring the endpoint configured for its
+// hostname or ID over the endpoint template
+func nodeStatsEndpoint(statsConfig types.StatsConfig, node types.Node) (string, error) {
+	if node.ID == "" {
+		return "", fmt.Errorf("the node is not running and active")
+	}
+
+	for _, key := range []string{node.Hostname, node.ID} {
+		if endpoint, ok := statsConfig.Endpoints[key]; ok {
+			return endpoint, nil
+		}
+	}
+
+	if statsConfig.Endpoint == "" {
+		return "", fmt.Errorf("no stats endpoint is configured for node %s", node.Hostname)
+	}
+
+	if strings.Contains(statsConfig.Endpoint, "{ip}") && node.IP == "" {
+		return "", fmt.Errorf("node %s has no known ip address", node.Hostname)
+	}
+
+	return strings.NewReplacer(
+		"{id}", node.ID,
+		"{hostname}", node.Hostname,
+		"{ip}", node.IP,
+	).Replace(statsConfig.Endpoint), nil
+}
@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"../collector"
+	"../types"
+)
+
+func TestNodeStatsEndpoint(t *testing.T) {
+	node := types.Node{ID: "node-1", Hostname: "worker-1", IP: "10.0.0.1"}
+
+	tests := []struct {
+		name        string
+		statsConfig types.StatsConfig
+		node        types.Node
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:        "template",
+			statsConfig: types.StatsConfig{Endpoint: "tcp://{ip}:2376"},
+			node:        node,
+			want:        "tcp://10.0.0.1:2376",
+		},
+		{
+			name:        "hostname and id template",
+			statsConfig: types.StatsConfig{Endpoint: "tcp://{hostname}.{id}.swarm:2375"},
+			node:        node,
+			want:        "tcp://worker-1.node-1.swarm:2375",
+		},
+		{
+			name: "hostname override",
+			statsConfig: types.StatsConfig{
+				Endpoint:  "tcp://{ip}:2376",
+				Endpoints: map[string]string{"worker-1": "tcp://192.168.1.1:2376"},
+			},
+			node: node,
+			want: "tcp://192.168.1.1:2376",
+		},
+		{
+			name: "id override",
+			statsConfig: types.StatsConfig{
+				Endpoints: map[string]string{"node-1": "tcp://192.168.1.1:2376"},
+			},
+			node: node,
+			want: "tcp://192.168.1.1:2376",
+		},
+		{
+			name: "no endpoint for the node",
+			statsConfig: types.StatsConfig{
+				Endpoints: map[string]string{"worker-2": "tcp://192.168.1.2:2376"},
+			},
+			node:    node,
+			wantErr: true,
+		},
+		{
+			name:        "unknown ip",
+			statsConfig: types.StatsConfig{Endpoint: "tcp://{ip}:2376"},
+			node:        types.Node{ID: "node-1", Hostname: "worker-1"},
+			wantErr:     true,
+		},
+		{
+			name:        "node not running",
+			statsConfig: types.StatsConfig{Endpoint: "tcp://{ip}:2376"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nodeStatsEndpoint(tt.statsConfig, tt.node)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("got endpoint %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/containers/container-1/stats") {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		var stats types.ContainerStatsRaw
+		stats.ID = "container-1"
+		stats.MemoryStats.Usage = 42
+
+		json.NewEncoder(w).Encode(stats)
+	}))
+	defer server.Close()
+
+	statsConfig := types.StatsConfig{
+		Source:   types.StatsSourceEngine,
+		Endpoint: "tcp://" + strings.TrimPrefix(server.URL, "http://"),
+	}
+
+	if err := SetStatsConfig(statsConfig); err != nil {
+		t.Fatalf("cannot set the stats configuration: %s", err)
+	}
+	defer SetStatsConfig(types.StatsConfig{})
+
+	stats, err := fetchStats(context.Background(), collector.Target{
+		ContainerID: "container-1",
+		Node:        types.Node{ID: "node-1", Hostname: "worker-1"},
+	})
+
+	if err != nil {
+		t.Fatalf("cannot fetch the stats: %s", err)
+	}
+
+	if stats.ID != "container-1" || stats.MemoryStats.Usage != 42 {
+		t.Errorf("got stats %+v, want the ones served by the engine", stats)
+	}
+}
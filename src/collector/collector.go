@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../types"
+)
+
+// Target is a container whose stats are collected and the node it runs on
+type Target struct {
+	ContainerID string
+	Node        types.Node
+}
+
+// FetchFunc retrieves a single stats sample of a container
+type FetchFunc func(ctx context.Context, target Target) (types.ContainerStatsRaw, error)
+
+// Sample is the latest stats sample of a container and the time it was collected at
+type Sample struct {
+	Stats     types.ContainerStatsRaw
+	Timestamp time.Time
+}
+
+// Collector fetches the stats of many containers concurrently with a bounded pool of workers and caches the latest
+// sample of each of them
+type Collector struct {
+	fetch   FetchFunc
+	workers int
+
+	lock   sync.RWMutex
+	latest map[string]Sample
+	// now timestamps the samples
+	now func() time.Time
+}
+
+// New creates a Collector that fetches stats with fetch, running at most workers requests at the same time
+func New(fetch FetchFunc, workers int) *Collector {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &Collector{
+		fetch:   fetch,
+		workers: workers,
+		latest:  map[string]Sample{},
+		now:     time.Now,
+	}
+}
+
+// SetClock replaces the function that timestamps the samples, which tests use to simulate the passing of time
+func (c *Collector) SetClock(now func() time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = now
+}
+
+// Collect refreshes the samples of the targets and forgets the samples of every other container, returning the number
+// of containers whose stats could not be fetched
+//
+// A container whose stats cannot be fetched loses its cached sample. When ctx is cancelled, e.g. by a stage timeout or
+// the shutdown, the containers that were not fetched yet or whose fetch was interrupted keep their previous sample
+func (c *Collector) Collect(ctx context.Context, targets []Target) int {
+	var failures int32
+	var wg sync.WaitGroup
+
+	workers := c.workers
+	if workers > len(targets) {
+		workers = len(targets)
+	}
+
+	jobs := make(chan Target)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for target := range jobs {
+				stats, err := c.fetch(ctx, target)
+
+				c.lock.Lock()
+				if err != nil {
+					// an interrupted fetch says nothing about the container
+					if ctx.Err() == nil {
+						delete(c.latest, target.ContainerID)
+					}
+				} else {
+					c.latest[target.ContainerID] = Sample{Stats: stats, Timestamp: c.now()}
+				}
+				c.lock.Unlock()
+
+				if err != nil {
+					atomic.AddInt32(&failures, 1)
+					log.Debugf("cannot fetch the stats of container %s on node %s: %s", target.ContainerID,
+						target.Node.ID, err)
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, target := range targets {
+		select {
+		case jobs <- target:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	c.reap(targets)
+
+	return int(failures)
+}
+
+// Latest returns the cached sample of a container
+func (c *Collector) Latest(containerID string) (Sample, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	s, ok := c.latest[containerID]
+
+	return s, ok
+}
+
+// reap forgets the samples of the containers that are not targets
+func (c *Collector) reap(targets []Target) {
+	running := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		running[t.ContainerID] = true
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for id := range c.latest {
+		if !running[id] {
+			delete(c.latest, id)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"../types"
+)
+
+func TestCollect(t *testing.T) {
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+
+	fetch := func(ctx context.Context, target Target) (types.ContainerStatsRaw, error) {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+
+		if target.ContainerID == "broken" {
+			return types.ContainerStatsRaw{}, fmt.Errorf("no such container: %s", target.ContainerID)
+		}
+
+		return types.ContainerStatsRaw{ID: target.ContainerID}, nil
+	}
+
+	c := New(fetch, 3)
+
+	targets := []Target{{ContainerID: "broken"}}
+	for i := 0; i < 10; i++ {
+		targets = append(targets, Target{ContainerID: fmt.Sprintf("container-%d", i)})
+	}
+
+	if failures := c.Collect(context.Background(), targets); failures != 1 {
+		t.Errorf("got %d failures, want 1", failures)
+	}
+
+	if maxInFlight > 3 {
+		t.Errorf("got %d concurrent requests, want at most 3", maxInFlight)
+	}
+
+	if s, ok := c.Latest("container-0"); !ok || s.Stats.ID != "container-0" {
+		t.Errorf("got sample %+v for container-0, want its stats", s)
+	}
+
+	if _, ok := c.Latest("broken"); ok {
+		t.Errorf("got a sample for a container whose stats cannot be fetched")
+	}
+
+	c.Collect(context.Background(), []Target{{ContainerID: "container-1"}})
+
+	if _, ok := c.Latest("container-0"); ok {
+		t.Errorf("got a sample for a container that disappeared")
+	}
+
+	if _, ok := c.Latest("container-1"); !ok {
+		t.Errorf("got no sample for a running container")
+	}
+}
+
+func TestCollectCancelled(t *testing.T) {
+	fetch := func(ctx context.Context, target Target) (types.ContainerStatsRaw, error) {
+		return types.ContainerStatsRaw{ID: target.ContainerID}, nil
+	}
+
+	c := New(fetch, 2)
+	c.Collect(context.Background(), []Target{{ContainerID: "container-1"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c.Collect(ctx, []Target{{ContainerID: "container-1"}, {ContainerID: "container-2"}})
+
+	// a sample that could not be refreshed is kept
+	if _, ok := c.Latest("container-1"); !ok {
+		t.Errorf("got no sample for a container that was not refreshed")
+	}
+}
+
+func TestCollectInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interrupt := false
+
+	// the fetch of container-1 is still in flight when ctx is cancelled
+	fetch := func(fetchCtx context.Context, target Target) (types.ContainerStatsRaw, error) {
+		if interrupt && target.ContainerID == "container-1" {
+			cancel()
+			<-fetchCtx.Done()
+
+			return types.ContainerStatsRaw{}, fetchCtx.Err()
+		}
+
+		return types.ContainerStatsRaw{ID: target.ContainerID}, nil
+	}
+
+	c := New(fetch, 1)
+	targets := []Target{{ContainerID: "container-1"}, {ContainerID: "container-2"}}
+
+	c.Collect(ctx, targets)
+	interrupt = true
+
+	if failures := c.Collect(ctx, targets); failures != 1 {
+		t.Errorf("got %d failures, want 1", failures)
+	}
+
+	for _, id := range []string{"container-1", "container-2"} {
+		if s, ok := c.Latest(id); !ok || s.Stats.ID != id {
+			t.Errorf("got sample %+v for %s, want its previous stats", s, id)
+		}
+	}
+}
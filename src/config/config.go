@@ -0,0 +1,382 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"../types"
+)
+
+// ValidationError describes a single invalid field in a ServicesConfig object
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors is the list of all the invalid fields found in a ServicesConfig object
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, ve := range e {
+		messages[i] = ve.Error()
+	}
+
+	return fmt.Sprintf("invalid configuration:\n\t%s", strings.Join(messages, "\n\t"))
+}
+
+// Load reads the json or yaml configuration file at path, parses it and validates the resulting ServicesConfig object
+func Load(path string) (types.ServicesConfig, error) {
+	var result types.ServicesConfig
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return result, err
+	}
+
+	result, err = Parse(data, isYAML(path))
+
+	if err != nil {
+		return result, fmt.Errorf("cannot parse configuration file %s: %s", path, err)
+	}
+
+	if err := Validate(result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Parse deserializes data into a ServicesConfig object, treating it as yaml if asYAML is true and as json otherwise
+func Parse(data []byte, asYAML bool) (types.ServicesConfig, error) {
+	var result types.ServicesConfig
+
+	if asYAML {
+		jsonData, err := yamlToJSON(data)
+
+		if err != nil {
+			return result, err
+		}
+
+		data = jsonData
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Validate checks a ServicesConfig object and reports every invalid field it contains
+func Validate(config types.ServicesConfig) error {
+	errs := ValidationErrors{}
+	fail := func(field string, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	serviceIndexes := map[string]int{}
+
+	for i, s := range config.Services {
+		field := fmt.Sprintf("services[%d]", i)
+
+		if s.Name == "" {
+			fail(field+".name", "must not be empty")
+		} else if j, ok := serviceIndexes[s.Name]; ok {
+			fail(field+".name", "duplicate service name %q, already used by services[%d]", s.Name, j)
+		} else {
+			serviceIndexes[s.Name] = i
+		}
+
+		if s.MinReplicas < 0 {
+			fail(field+".min_replicas", "must not be negative, got %d", s.MinReplicas)
+		}
+
+		if s.MaxReplicas < 1 {
+			fail(field+".max_replicas", "must be at least 1, got %d", s.MaxReplicas)
+		}
+
+		if s.MinReplicas > s.MaxReplicas {
+			fail(field+".min_replicas", "must not be greater than max_replicas (%d), got %d", s.MaxReplicas, s.MinReplicas)
+		}
+
+		switch s.ScalingMode {
+		case "", types.ScalingModeNodeLabel:
+			if s.NodeLabel == "" {
+				fail(field+".node_label", "must not be empty when scaling by node label")
+			}
+		case types.ScalingModeReplicas:
+			if s.ScaleInStrategy != "" {
+				fail(field+".scale_in_strategy", "is only used when scaling by node label, docker picks the tasks it stops "+
+					"when scaling by replicas")
+			}
+		default:
+			fail(field+".scaling_mode", "must be %q or %q, got %q",
+				types.ScalingModeNodeLabel, types.ScalingModeReplicas, s.ScalingMode)
+		}
+
+		switch s.CPUMode {
+		case "", types.CPUModeHost, types.CPUModeLimit:
+		default:
+			fail(field+".cpu_mode", "must be %q or %q, got %q", types.CPUModeHost, types.CPUModeLimit, s.CPUMode)
+		}
+
+		switch s.ScaleInStrategy {
+		case "", types.ScaleInStrategyLeastLoaded, types.ScaleInStrategyNewest, types.ScaleInStrategyOldest,
+			types.ScaleInStrategyMostOtherLoad:
+		default:
+			fail(field+".scale_in_strategy", "must be %q, %q, %q or %q, got %q", types.ScaleInStrategyLeastLoaded,
+				types.ScaleInStrategyNewest, types.ScaleInStrategyOldest, types.ScaleInStrategyMostOtherLoad,
+				s.ScaleInStrategy)
+		}
+
+		switch s.Policy {
+		case "", types.PolicyThreshold:
+		case types.PolicyTargetTracking:
+			validateTargetTracking(s.TargetTracking, field+".target_tracking", fail)
+		case types.PolicyStep:
+			if len(s.ScaleOut.Steps) == 0 && len(s.ScaleIn.Steps) == 0 {
+				fail(field+".scale_out.steps", "must not be empty with the step policy unless scale_in.steps is set")
+			}
+		default:
+			fail(field+".policy", "must be %q, %q or %q, got %q", types.PolicyThreshold, types.PolicyTargetTracking,
+				types.PolicyStep, s.Policy)
+		}
+
+		validateScaleConditions(s.ScaleOut, field+".scale_out", s.Policy == types.PolicyStep, fail)
+		validateScaleConditions(s.ScaleIn, field+".scale_in", s.Policy == types.PolicyStep, fail)
+	}
+
+	validateStatsConfig(config.Stats, fail)
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// validateScaleConditions checks the conditions of a scaling direction, whose steps are only allowed with the step
+// policy
+func validateScaleConditions(conditions types.ServiceScaleConditions, field string, stepPolicy bool,
+	fail func(field string, format string, args ...interface{})) {
+	if conditions.CPU < 0 {
+		fail(field+".cpu", "must not be negative, got %g", conditions.CPU)
+	}
+
+	if conditions.Memory < 0 || conditions.Memory > 100 {
+		fail(field+".memory", "must be a percentage between 0 and 100, got %g", conditions.Memory)
+	}
+
+	if len(conditions.Steps) > 0 && !stepPolicy {
+		fail(field+".steps", "is only used by the %q policy", types.PolicyStep)
+	}
+
+	for i, step := range conditions.Steps {
+		stepField := fmt.Sprintf("%s.steps[%d]", field, i)
+
+		if step.CPU < 0 {
+			fail(stepField+".cpu", "must not be negative, got %g", step.CPU)
+		}
+
+		if step.Memory < 0 || step.Memory > 100 {
+			fail(stepField+".memory", "must be a percentage between 0 and 100, got %g", step.Memory)
+		}
+
+		if step.CPU == 0 && step.Memory == 0 {
+			fail(stepField, "must set a cpu or memory threshold")
+		}
+
+		if step.Change < 1 {
+			fail(stepField+".change", "must be at least 1, got %d", step.Change)
+		}
+	}
+
+	switch conditions.Statistic {
+	case "", types.StatisticAverage, types.StatisticMax, types.StatisticP50, types.StatisticP90, types.StatisticP95,
+		types.StatisticP99:
+	default:
+		fail(field+".statistic", "must be one of %s, %s, %s, %s, %s or %s, got %q", types.StatisticAverage,
+			types.StatisticMax, types.StatisticP50, types.StatisticP90, types.StatisticP95, types.StatisticP99,
+			conditions.Statistic)
+	}
+
+	validateDuration(conditions.Period, field+".period", fail)
+	validateDuration(conditions.Cooldown, field+".cooldown", fail)
+}
+
+// validateDuration checks an optional duration
+func validateDuration(value string, field string, fail func(field string, format string, args ...interface{})) {
+	if value == "" {
+		return
+	}
+
+	if duration, err := time.ParseDuration(value); err != nil {
+		fail(field, "invalid duration %q", value)
+	} else if duration < 0 {
+		fail(field, "must not be negative, got %s", value)
+	}
+}
+
+// validateTargetTracking
+func validateTargetTracking(targetTracking types.TargetTrackingConfig, field string,
+	fail func(field string, format string, args ...interface{})) {
+	if targetTracking.CPU < 0 {
+		fail(field+".cpu", "must not be negative, got %g", targetTracking.CPU)
+	}
+
+	if targetTracking.Memory < 0 || targetTracking.Memory > 100 {
+		fail(field+".memory", "must be a percentage between 0 and 100, got %g", targetTracking.Memory)
+	}
+
+	if targetTracking.CPU == 0 && targetTracking.Memory == 0 {
+		fail(field, "must set a cpu or memory target")
+	}
+
+	if tolerance := targetTracking.GetTolerance(); tolerance < 0 || tolerance >= 1 {
+		fail(field+".tolerance", "must be at least 0 and less than 1, got %g", tolerance)
+	}
+}
+
+// validateStatsConfig
+func validateStatsConfig(statsConfig types.StatsConfig, fail func(field string, format string, args ...interface{})) {
+	switch statsConfig.Source {
+	case "", types.StatsSourceLocal:
+	case types.StatsSourceEngine, types.StatsSourceAgent:
+		if statsConfig.Endpoint == "" && len(statsConfig.Endpoints) == 0 {
+			fail("stats.endpoint", "must not be empty when fetching stats from the %s of every node", statsConfig.Source)
+		}
+	default:
+		fail("stats.source", "must be %q, %q or %q, got %q", types.StatsSourceLocal, types.StatsSourceEngine,
+			types.StatsSourceAgent, statsConfig.Source)
+	}
+
+	if (statsConfig.TLS.Cert == "") != (statsConfig.TLS.Key == "") {
+		fail("stats.tls", "cert and key must be set together")
+	}
+}
+
+// isYAML
+func isYAML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+
+	return false
+}
+
+// yamlToJSON converts a yaml document to json so that both formats share the json field names and decoding rules
+func yamlToJSON(data []byte) ([]byte, error) {
+	var document interface{}
+
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	document, err := convertYAMLValue(document, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(document)
+}
+
+// convertYAMLValue
+func convertYAMLValue(value interface{}, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := map[string]interface{}{}
+
+		for key, item := range v {
+			k, ok := key.(string)
+
+			if !ok {
+				return nil, fmt.Errorf("%s: non-string key %v", path, key)
+			}
+
+			converted, err := convertYAMLValue(item, joinPath(path, k))
+
+			if err != nil {
+				return nil, err
+			}
+
+			result[k] = converted
+		}
+
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+
+		for i, item := range v {
+			converted, err := convertYAMLValue(item, fmt.Sprintf("%s[%d]", path, i))
+
+			if err != nil {
+				return nil, err
+			}
+
+			result[i] = converted
+		}
+
+		return result, nil
+	}
+
+	return value, nil
+}
+
+// joinPath
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// Diff lists the names of the services that were added, removed or changed between two ServicesConfig objects
+func Diff(oldConfig types.ServicesConfig, newConfig types.ServicesConfig) (added []string, removed []string, changed []string) {
+	added = []string{}
+	removed = []string{}
+	changed = []string{}
+
+	oldServices := map[string]types.ServiceConfig{}
+	for _, s := range oldConfig.Services {
+		oldServices[s.Name] = s
+	}
+
+	newServices := map[string]bool{}
+	for _, s := range newConfig.Services {
+		newServices[s.Name] = true
+
+		if o, ok := oldServices[s.Name]; !ok {
+			added = append(added, s.Name)
+		} else if !reflect.DeepEqual(o, s) {
+			changed = append(changed, s.Name)
+		}
+	}
+
+	for _, s := range oldConfig.Services {
+		if !newServices[s.Name] {
+			removed = append(removed, s.Name)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,299 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"../types"
+)
+
+// validService
+func validService(name string) types.ServiceConfig {
+	return types.ServiceConfig{
+		Name:        name,
+		MinReplicas: 1,
+		MaxReplicas: 3,
+		NodeLabel:   name,
+		ScaleOut:    types.ServiceScaleConditions{CPU: 50, Memory: 50, Period: "1m"},
+		ScaleIn:     types.ServiceScaleConditions{CPU: 10, Memory: 25, Period: "1m"},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(s *types.ServiceConfig)
+		stats  types.StatsConfig
+		// others are services validated after the modified one
+		others     []types.ServiceConfig
+		wantFields []string
+	}{
+		{
+			name:   "valid",
+			modify: func(s *types.ServiceConfig) {},
+		},
+		{
+			name:       "min replicas greater than max replicas",
+			modify:     func(s *types.ServiceConfig) { s.MinReplicas = 4 },
+			wantFields: []string{"services[0].min_replicas"},
+		},
+		{
+			name: "negative values",
+			modify: func(s *types.ServiceConfig) {
+				s.MinReplicas = -1
+				s.ScaleOut.CPU = -5
+				s.ScaleIn.Memory = -1
+			},
+			wantFields: []string{"services[0].min_replicas", "services[0].scale_out.cpu", "services[0].scale_in.memory"},
+		},
+		{
+			name:       "max replicas below 1",
+			modify:     func(s *types.ServiceConfig) { s.MinReplicas, s.MaxReplicas = 0, 0 },
+			wantFields: []string{"services[0].max_replicas"},
+		},
+		{
+			name:       "memory above 100",
+			modify:     func(s *types.ServiceConfig) { s.ScaleOut.Memory = 120 },
+			wantFields: []string{"services[0].scale_out.memory"},
+		},
+		{
+			name: "bad durations",
+			modify: func(s *types.ServiceConfig) {
+				s.ScaleOut.Period = "1x"
+				s.ScaleIn.Period = "-1m"
+				s.ScaleOut.Cooldown = "soon"
+				s.ScaleIn.Cooldown = "-5m"
+			},
+			wantFields: []string{"services[0].scale_out.period", "services[0].scale_out.cooldown",
+				"services[0].scale_in.period", "services[0].scale_in.cooldown"},
+		},
+		{
+			name:       "empty node label in node_label mode",
+			modify:     func(s *types.ServiceConfig) { s.NodeLabel = "" },
+			wantFields: []string{"services[0].node_label"},
+		},
+		{
+			name: "empty node label in replicas mode",
+			modify: func(s *types.ServiceConfig) {
+				s.NodeLabel = ""
+				s.ScalingMode = types.ScalingModeReplicas
+			},
+		},
+		{
+			name:       "empty name",
+			modify:     func(s *types.ServiceConfig) { s.Name = "" },
+			wantFields: []string{"services[0].name"},
+		},
+		{
+			name:       "duplicate names",
+			modify:     func(s *types.ServiceConfig) {},
+			others:     []types.ServiceConfig{validService("api"), validService("portainer")},
+			wantFields: []string{"services[2].name"},
+		},
+		{
+			name:       "unknown scaling mode",
+			modify:     func(s *types.ServiceConfig) { s.ScalingMode = "labels" },
+			wantFields: []string{"services[0].scaling_mode"},
+		},
+		{
+			name:       "unknown cpu mode",
+			modify:     func(s *types.ServiceConfig) { s.CPUMode = "quota" },
+			wantFields: []string{"services[0].cpu_mode"},
+		},
+		{
+			name:       "unknown policy",
+			modify:     func(s *types.ServiceConfig) { s.Policy = "predictive" },
+			wantFields: []string{"services[0].policy"},
+		},
+		{
+			name:       "unknown statistic",
+			modify:     func(s *types.ServiceConfig) { s.ScaleIn.Statistic = "p42" },
+			wantFields: []string{"services[0].scale_in.statistic"},
+		},
+		{
+			name:       "unknown scale in strategy",
+			modify:     func(s *types.ServiceConfig) { s.ScaleInStrategy = "random" },
+			wantFields: []string{"services[0].scale_in_strategy"},
+		},
+		{
+			name: "scale in strategy in replicas mode",
+			modify: func(s *types.ServiceConfig) {
+				s.ScalingMode = types.ScalingModeReplicas
+				s.ScaleInStrategy = types.ScaleInStrategyNewest
+			},
+			wantFields: []string{"services[0].scale_in_strategy"},
+		},
+		{
+			name: "target tracking without target",
+			modify: func(s *types.ServiceConfig) {
+				s.Policy = types.PolicyTargetTracking
+			},
+			wantFields: []string{"services[0].target_tracking"},
+		},
+		{
+			name: "steps without the step policy",
+			modify: func(s *types.ServiceConfig) {
+				s.ScaleOut.Steps = []types.ScalingStep{{CPU: 60, Change: 1}}
+			},
+			wantFields: []string{"services[0].scale_out.steps"},
+		},
+		{
+			name: "invalid step",
+			modify: func(s *types.ServiceConfig) {
+				s.Policy = types.PolicyStep
+				s.ScaleOut.Steps = []types.ScalingStep{{CPU: 60, Change: 0}}
+			},
+			wantFields: []string{"services[0].scale_out.steps[0].change"},
+		},
+		{
+			name:       "unknown stats source",
+			modify:     func(s *types.ServiceConfig) {},
+			stats:      types.StatsConfig{Source: "prometheus"},
+			wantFields: []string{"stats.source"},
+		},
+		{
+			name: "every error at once",
+			modify: func(s *types.ServiceConfig) {
+				s.MinReplicas = 5
+				s.ScaleOut.Period = "1x"
+			},
+			others: []types.ServiceConfig{
+				func() types.ServiceConfig {
+					s := validService("api")
+					s.NodeLabel = ""
+					return s
+				}(),
+			},
+			stats: types.StatsConfig{Source: types.StatsSourceEngine},
+			wantFields: []string{"services[0].min_replicas", "services[0].scale_out.period", "services[1].node_label",
+				"stats.endpoint"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := validService("portainer")
+			tt.modify(&s)
+
+			err := Validate(types.ServicesConfig{Services: append([]types.ServiceConfig{s}, tt.others...), Stats: tt.stats})
+
+			if tt.wantFields == nil {
+				if err != nil {
+					t.Fatalf("got error %s, want none", err)
+				}
+
+				return
+			}
+
+			errs, ok := err.(ValidationErrors)
+
+			if !ok {
+				t.Fatalf("got error %v, want ValidationErrors", err)
+			}
+
+			fields := []string{}
+			for _, e := range errs {
+				fields = append(fields, e.Field)
+			}
+
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("got invalid fields %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	data := []byte(`
+services:
+  - name: portainer
+    max_replicas: 3
+    node_label: portainer
+    scale_out:
+      period: 1x
+`)
+
+	servicesConfig, err := Parse(data, true)
+
+	if err != nil {
+		t.Fatalf("cannot parse the configuration: %s", err)
+	}
+
+	err = Validate(servicesConfig)
+	want := "invalid configuration:\n\tservices[0].scale_out.period: invalid duration \"1x\""
+
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	changedService := validService("api")
+	changedService.MaxReplicas = 5
+
+	tests := []struct {
+		name        string
+		oldServices []types.ServiceConfig
+		newServices []types.ServiceConfig
+		wantAdded   []string
+		wantRemoved []string
+		wantChanged []string
+	}{
+		{
+			name:        "unchanged",
+			oldServices: []types.ServiceConfig{validService("api"), validService("portainer")},
+			newServices: []types.ServiceConfig{validService("api"), validService("portainer")},
+		},
+		{
+			name:        "reordered",
+			oldServices: []types.ServiceConfig{validService("api"), validService("portainer")},
+			newServices: []types.ServiceConfig{validService("portainer"), validService("api")},
+		},
+		{
+			name:        "first configuration",
+			newServices: []types.ServiceConfig{validService("api"), validService("portainer")},
+			wantAdded:   []string{"api", "portainer"},
+		},
+		{
+			name:        "added, removed and changed",
+			oldServices: []types.ServiceConfig{validService("api"), validService("portainer"), validService("web")},
+			newServices: []types.ServiceConfig{changedService, validService("web"), validService("worker")},
+			wantAdded:   []string{"worker"},
+			wantRemoved: []string{"portainer"},
+			wantChanged: []string{"api"},
+		},
+		{
+			name:        "every service removed",
+			oldServices: []types.ServiceConfig{validService("api"), validService("portainer")},
+			wantRemoved: []string{"api", "portainer"},
+		},
+	}
+
+	// normalize lets the expectations leave out the empty lists
+	normalize := func(names []string) []string {
+		if names == nil {
+			return []string{}
+		}
+
+		return names
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed, changed := Diff(types.ServicesConfig{Services: tt.oldServices},
+				types.ServicesConfig{Services: tt.newServices})
+
+			if !reflect.DeepEqual(added, normalize(tt.wantAdded)) {
+				t.Errorf("got added services %v, want %v", added, tt.wantAdded)
+			}
+
+			if !reflect.DeepEqual(removed, normalize(tt.wantRemoved)) {
+				t.Errorf("got removed services %v, want %v", removed, tt.wantRemoved)
+			}
+
+			if !reflect.DeepEqual(changed, normalize(tt.wantChanged)) {
+				t.Errorf("got changed services %v, want %v", changed, tt.wantChanged)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"time"
+)
+
+// Watch polls the file at path every interval and signals on the returned channel whenever its size, modification
+// time or content changes, which also covers editors and orchestrators that replace the file instead of writing to it
+// and rewrites that keep both the size and the modification time of the file. Changes are only noticed on the next
+// poll, so they may be signaled up to interval after they are made
+//
+// Polling stops when done is closed
+func Watch(path string, interval time.Duration, done <-chan struct{}) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	last := readFileVersion(path)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				current := readFileVersion(path)
+
+				if current == last {
+					continue
+				}
+
+				last = current
+
+				// a pending notification already covers this change
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changed
+}
+
+// fileVersion identifies a version of a watched file
+type fileVersion struct {
+	size    int64
+	modTime int64
+	sum     [sha256.Size]byte
+}
+
+// readFileVersion returns the version of the file at path, which is the zero version with a size of -1 when the file
+// cannot be read
+func readFileVersion(path string) fileVersion {
+	missing := fileVersion{size: -1}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return missing
+	}
+
+	defer file.Close()
+
+	stat, err := file.Stat()
+
+	if err != nil {
+		return missing
+	}
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return missing
+	}
+
+	version := fileVersion{size: stat.Size(), modTime: stat.ModTime().UnixNano()}
+	copy(version.sum[:], hash.Sum(nil))
+
+	return version
+}
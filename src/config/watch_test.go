@@ -0,0 +1,152 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// watchInterval is short enough for changes to be noticed quickly by the tests
+const watchInterval = 10 * time.Millisecond
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "services.yml")
+	modTime := time.Unix(1500000000, 0)
+
+	// write replaces the content of the file, moving its modification time forward as fast writes may not change it
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		modTime = modTime.Add(time.Minute)
+
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("services: []\n")
+
+	done := make(chan struct{})
+	defer close(done)
+
+	changed := Watch(path, watchInterval, done)
+
+	steps := []struct {
+		name       string
+		change     func()
+		wantSignal bool
+	}{
+		{name: "unchanged file", change: func() {}},
+		{
+			name:       "file written to",
+			change:     func() { write("services:\n  - name: portainer\n") },
+			wantSignal: true,
+		},
+		{
+			name: "file replaced",
+			change: func() {
+				replacement := filepath.Join(dir, "services.yml.new")
+
+				if err := ioutil.WriteFile(replacement, []byte("services: []\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.Rename(replacement, path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantSignal: true,
+		},
+		{name: "unchanged file after a change", change: func() {}},
+		{
+			name: "file rewritten with the same size and modification time",
+			change: func() {
+				stat, err := os.Stat(path)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := ioutil.WriteFile(path, []byte("services: {}\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.Chtimes(path, stat.ModTime(), stat.ModTime()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantSignal: true,
+		},
+		{
+			name: "file removed",
+			change: func() {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantSignal: true,
+		},
+		{name: "file still missing", change: func() {}},
+	}
+
+	for _, step := range steps {
+		step.change()
+
+		if got := waitForSignal(changed, step.wantSignal); got != step.wantSignal {
+			t.Errorf("%s: got change signaled %t, want %t", step.name, got, step.wantSignal)
+		}
+	}
+}
+
+func TestWatchStops(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "services.yml")
+	done := make(chan struct{})
+	changed := Watch(path, watchInterval, done)
+
+	close(done)
+	time.Sleep(5 * watchInterval)
+
+	if err := ioutil.WriteFile(path, []byte("services: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if waitForSignal(changed, false) {
+		t.Error("got a change signaled after the watch was stopped")
+	}
+}
+
+// waitForSignal reports whether a change is signaled on changed, waiting long enough for several polls when none is
+// expected and giving up after a few seconds otherwise
+func waitForSignal(changed <-chan struct{}, expected bool) bool {
+	timeout := 10 * watchInterval
+	if expected {
+		timeout = 5 * time.Second
+	}
+
+	select {
+	case <-changed:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
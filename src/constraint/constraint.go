@@ -0,0 +1,180 @@
+package constraint
+
+import (
+	"fmt"
+	"strings"
+
+	"../types"
+)
+
+const (
+	// OperatorEqual
+	OperatorEqual = "=="
+	// OperatorNotEqual
+	OperatorNotEqual = "!="
+)
+
+// Constraint is a parsed swarm placement constraint expression like node.role == manager
+type Constraint struct {
+	Key      string
+	Operator string
+	Value    string
+}
+
+// architectureAliases maps the architectures reported by the nodes to the names the docker scheduler also accepts
+var architectureAliases = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+}
+
+// Parse parses a constraint expression, failing on the expressions the docker scheduler would reject
+func Parse(expr string) (Constraint, error) {
+	operator := OperatorEqual
+	if strings.Contains(expr, OperatorNotEqual) {
+		operator = OperatorNotEqual
+	}
+
+	parts := strings.SplitN(expr, operator, 2)
+
+	if len(parts) != 2 {
+		return Constraint{}, fmt.Errorf("invalid constraint %q: no == or != operator", expr)
+	}
+
+	c := Constraint{
+		Key:      strings.TrimSpace(parts[0]),
+		Operator: operator,
+		Value:    strings.TrimSpace(parts[1]),
+	}
+
+	if c.Key == "" || strings.ContainsAny(c.Key, " \t") {
+		return Constraint{}, fmt.Errorf("invalid constraint %q: invalid key %q", expr, c.Key)
+	}
+
+	if c.Value == "" || strings.ContainsAny(c.Value, "=!") {
+		return Constraint{}, fmt.Errorf("invalid constraint %q: invalid value %q", expr, c.Value)
+	}
+
+	switch key := strings.ToLower(c.Key); {
+	case key == "node.id", key == "node.hostname", key == "node.role",
+		key == "node.platform.os", key == "node.platform.arch":
+	case strings.HasPrefix(key, "node.labels.") && len(key) > len("node.labels."):
+	case strings.HasPrefix(key, "engine.labels.") && len(key) > len("engine.labels."):
+	default:
+		return Constraint{}, fmt.Errorf("invalid constraint %q: unknown key %q", expr, c.Key)
+	}
+
+	return c, nil
+}
+
+// ParseAll parses every constraint expression of a service
+func ParseAll(exprs []string) ([]Constraint, error) {
+	constraints := make([]Constraint, 0, len(exprs))
+
+	for _, expr := range exprs {
+		c, err := Parse(expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		constraints = append(constraints, c)
+	}
+
+	return constraints, nil
+}
+
+// Match reports whether a node satisfies the constraint
+//
+// Like the docker scheduler, keys and values are compared case insensitively and a constraint on a label the node does
+// not have only matches with the != operator
+func (c Constraint) Match(node types.Node) bool {
+	var candidates []string
+
+	switch key := strings.ToLower(c.Key); {
+	case key == "node.id":
+		candidates = []string{node.ID}
+	case key == "node.hostname":
+		candidates = []string{node.Hostname}
+	case key == "node.role":
+		candidates = []string{node.Role}
+	case key == "node.platform.os":
+		candidates = []string{node.Platform.OS}
+	case key == "node.platform.arch":
+		candidates = []string{node.Platform.Architecture}
+		if alias, ok := architectureAliases[node.Platform.Architecture]; ok {
+			candidates = append(candidates, alias)
+		}
+	case strings.HasPrefix(key, "node.labels."):
+		if value, ok := node.Labels[c.Key[len("node.labels."):]]; ok {
+			candidates = []string{value}
+		}
+	case strings.HasPrefix(key, "engine.labels."):
+		if value, ok := node.EngineLabels[c.Key[len("engine.labels."):]]; ok {
+			candidates = []string{value}
+		}
+	}
+
+	matched := false
+	for _, candidate := range candidates {
+		if strings.EqualFold(candidate, c.Value) {
+			matched = true
+
+			break
+		}
+	}
+
+	if c.Operator == OperatorNotEqual {
+		return !matched
+	}
+
+	return matched
+}
+
+// MatchAll reports whether a node satisfies every constraint
+func MatchAll(constraints []Constraint, node types.Node) bool {
+	for _, c := range constraints {
+		if !c.Match(node) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchPlatforms reports whether a node runs one of the platforms of a service, any node matching when there are none
+//
+// Like the docker scheduler, the architectures are compared after normalizing their aliases and an empty operating
+// system or architecture matches any
+func MatchPlatforms(platforms []types.Platform, node types.Node) bool {
+	if len(platforms) == 0 {
+		return true
+	}
+
+	nodeArchitecture := normalizeArchitecture(node.Platform.Architecture)
+
+	for _, p := range platforms {
+		osMatched := p.OS == "" || strings.EqualFold(p.OS, node.Platform.OS)
+		architectureMatched := p.Architecture == "" ||
+			strings.EqualFold(normalizeArchitecture(p.Architecture), nodeArchitecture)
+
+		if osMatched && architectureMatched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeArchitecture returns the name the docker scheduler gives an architecture
+func normalizeArchitecture(architecture string) string {
+	if alias, ok := architectureAliases[strings.ToLower(architecture)]; ok {
+		return alias
+	}
+
+	return architecture
+}
+
+// String
+func (c Constraint) String() string {
+	return c.Key + " " + c.Operator + " " + c.Value
+}
@@ -0,0 +1,124 @@
+package constraint
+
+import (
+	"testing"
+
+	"../types"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    Constraint
+		wantErr bool
+	}{
+		{expr: "node.role == manager", want: Constraint{"node.role", OperatorEqual, "manager"}},
+		{expr: "node.labels.zone!=eu-1", want: Constraint{"node.labels.zone", OperatorNotEqual, "eu-1"}},
+		{expr: "Engine.Labels.storage == ssd", want: Constraint{"Engine.Labels.storage", OperatorEqual, "ssd"}},
+		{expr: "node.platform.arch == amd64", want: Constraint{"node.platform.arch", OperatorEqual, "amd64"}},
+		{expr: "node.role = manager", wantErr: true},
+		{expr: "node.role ==", wantErr: true},
+		{expr: "== manager", wantErr: true},
+		{expr: "node.labels. == 1", wantErr: true},
+		{expr: "node.name == worker-1", wantErr: true},
+		{expr: "node.role === manager", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.expr)
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, want error %t", tt.expr, err, tt.wantErr)
+
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	node := types.Node{
+		ID:           "node-1",
+		Hostname:     "worker-1",
+		Role:         "worker",
+		Labels:       map[string]string{"zone": "eu-1", "Disk": "SSD"},
+		EngineLabels: map[string]string{"storage": "ssd"},
+		Platform:     types.Platform{OS: "linux", Architecture: "x86_64"},
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"node.id == node-1", true},
+		{"node.id == node", false},
+		{"node.hostname == worker-1", true},
+		{"node.hostname != worker-1", false},
+		{"node.role == manager", false},
+		{"NODE.ROLE == Worker", true},
+		{"node.labels.zone == eu-1", true},
+		{"node.labels.zone != eu-2", true},
+		{"node.labels.Disk == ssd", true},
+		{"node.labels.disk == ssd", false},
+		{"node.labels.rack == 1", false},
+		{"node.labels.rack != 1", true},
+		{"engine.labels.storage == ssd", true},
+		{"engine.labels.storage == hdd", false},
+		{"node.platform.os == linux", true},
+		{"node.platform.os == windows", false},
+		{"node.platform.arch == x86_64", true},
+		{"node.platform.arch == amd64", true},
+		{"node.platform.arch == arm64", false},
+	}
+
+	for _, tt := range tests {
+		c, err := Parse(tt.expr)
+
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %s", tt.expr, err)
+		}
+
+		if got := c.Match(node); got != tt.want {
+			t.Errorf("%q.Match() = %t, want %t", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPlatforms(t *testing.T) {
+	node := types.Node{ID: "node-1", Platform: types.Platform{OS: "linux", Architecture: "x86_64"}}
+
+	tests := []struct {
+		name      string
+		platforms []types.Platform
+		want      bool
+	}{
+		{name: "no platforms", want: true},
+		{name: "same platform", platforms: []types.Platform{{OS: "linux", Architecture: "x86_64"}}, want: true},
+		{name: "architecture alias", platforms: []types.Platform{{OS: "linux", Architecture: "amd64"}}, want: true},
+		{name: "any architecture", platforms: []types.Platform{{OS: "Linux"}}, want: true},
+		{name: "any operating system", platforms: []types.Platform{{Architecture: "amd64"}}, want: true},
+		{name: "architecture mismatch", platforms: []types.Platform{{OS: "linux", Architecture: "arm64"}}, want: false},
+		{
+			name:      "operating system mismatch",
+			platforms: []types.Platform{{OS: "windows", Architecture: "amd64"}},
+			want:      false,
+		},
+		{
+			name: "one of several platforms",
+			platforms: []types.Platform{
+				{OS: "linux", Architecture: "arm64"},
+				{OS: "linux", Architecture: "amd64"},
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := MatchPlatforms(tt.platforms, node); got != tt.want {
+			t.Errorf("%s: MatchPlatforms() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
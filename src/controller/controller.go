@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// now and after tell the time and wait for it to pass, they are replaced in tests to simulate the passing of time
+	now   = time.Now
+	after = time.After
+)
+
+// Stage is a single step of a reconcile iteration, like refreshing the cluster state or applying scaling decisions
+type Stage struct {
+	Name string
+	// Interval is the minimum time between two runs of the stage, a zero Interval runs the stage on every tick
+	Interval time.Duration
+	// Timeout bounds a single run of the stage, a zero Timeout leaves it unbounded
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+
+	lastRun time.Time
+}
+
+// Controller runs its stages in order on every tick until its context is cancelled
+//
+// A tick never starts before the previous one has finished, so a slow stage delays the following ticks instead of
+// overlapping with them
+type Controller struct {
+	tick            time.Duration
+	jitter          float64
+	shutdownTimeout time.Duration
+	stages          []*Stage
+}
+
+// New creates a Controller that ticks every tick, randomly shifted by up to jitter (a fraction of tick) so that
+// multiple controllers do not hit the docker api in lockstep
+//
+// When the controller is stopped, a running stage is given shutdownTimeout to finish before its context is cancelled
+func New(tick time.Duration, jitter float64, shutdownTimeout time.Duration, stages ...*Stage) (*Controller, error) {
+	if tick <= 0 {
+		return nil, fmt.Errorf("the poll interval must be positive, got %s", tick)
+	}
+
+	if jitter < 0 || jitter >= 1 {
+		return nil, fmt.Errorf("the jitter must be at least 0 and less than 1, got %g", jitter)
+	}
+
+	return &Controller{
+		tick:            tick,
+		jitter:          jitter,
+		shutdownTimeout: shutdownTimeout,
+		stages:          stages,
+	}, nil
+}
+
+// Run executes reconcile iterations until ctx is cancelled
+//
+// Cancelling ctx prevents any new stage from starting, while the stage in progress keeps running until it finishes or
+// the shutdown timeout expires
+func (c *Controller) Run(ctx context.Context) {
+	stagesCtx, cancelStages := drainContext(ctx, c.shutdownTimeout)
+	defer cancelStages()
+
+	var next time.Duration
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-after(next):
+		}
+
+		started := now()
+		c.iterate(ctx, stagesCtx, started)
+		elapsed := now().Sub(started)
+
+		next = c.nextTick()
+
+		if elapsed > c.tick {
+			log.WithField("elapsed", elapsed.String()).Warnf(
+				"reconcile iteration overran the %s tick, starting the next one immediately", c.tick)
+
+			next = 0
+		} else {
+			next -= elapsed
+		}
+	}
+}
+
+// iterate runs, in order, every stage whose interval has elapsed and skips the rest of the iteration when one fails
+// or ctx is cancelled
+func (c *Controller) iterate(ctx context.Context, stagesCtx context.Context, now time.Time) {
+	for _, s := range c.stages {
+		if ctx.Err() != nil {
+			log.Infof("skipping stage %s because the controller is stopping", s.Name)
+
+			return
+		}
+
+		if !s.lastRun.IsZero() && now.Sub(s.lastRun) < s.Interval {
+			continue
+		}
+
+		s.lastRun = now
+
+		if err := c.runStage(stagesCtx, s); err != nil {
+			log.Errorf("stage %s failed, skipping the rest of the iteration: %s", s.Name, err)
+
+			return
+		}
+	}
+}
+
+// runStage runs a single stage under its timeout, turning a panic into an error so that the loop survives it
+func (c *Controller) runStage(ctx context.Context, s *Stage) (err error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	started := now()
+	err = s.Run(ctx)
+
+	log.WithField("elapsed", now().Sub(started).String()).Debugf("stage %s finished", s.Name)
+
+	return err
+}
+
+// nextTick
+func (c *Controller) nextTick() time.Duration {
+	if c.jitter <= 0 {
+		return c.tick
+	}
+
+	// shift the tick by a random amount in [-jitter, +jitter)
+	shift := (rand.Float64()*2 - 1) * c.jitter * float64(c.tick)
+
+	return c.tick + time.Duration(shift)
+}
+
+// drainContext returns a context that is cancelled timeout after parent is done, giving in-flight work time to finish
+//
+// The returned cancel function also waits for the timer watching parent to stop
+func drainContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-parent.Done():
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-after(timeout):
+			log.Warnf("shutdown timeout of %s expired, cancelling the stage in progress", timeout)
+			cancel()
+		}
+	}()
+
+	return ctx, func() {
+		cancel()
+		<-stopped
+	}
+}
@@ -0,0 +1,396 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+
+	os.Exit(m.Run())
+}
+
+// fakeClock is a clock whose time only passes when it is advanced
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+// fakeWaiter is a channel waiting for the fake time to reach at
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// useFakeClock replaces the clock of the package until the test ends
+func useFakeClock(t *testing.T) *fakeClock {
+	c := &fakeClock{now: time.Unix(1500000000, 0)}
+
+	now = c.Now
+	after = c.After
+	t.Cleanup(func() {
+		now = time.Now
+		after = time.After
+	})
+
+	return c
+}
+
+// Now
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+// After
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ch := make(chan time.Time, 1)
+
+	if d <= 0 {
+		ch <- c.now
+
+		return ch
+	}
+
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+
+	return ch
+}
+
+// Advance moves the time forward by d, waking up the waiters whose time came
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+
+	waiters := []fakeWaiter{}
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+
+	c.waiters = waiters
+}
+
+// waitForWaiter blocks until something waits for the time to pass
+func (c *fakeClock) waitForWaiter(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		c.lock.Lock()
+		waiting := len(c.waiters) > 0
+		c.lock.Unlock()
+
+		if waiting {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatal("nothing is waiting for the time to pass")
+}
+
+// receive waits for a value from ch, failing the test if none comes
+func receive(t *testing.T, ch <-chan string) string {
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a stage")
+	}
+
+	return ""
+}
+
+// runController runs c in the background and returns a function that stops it and waits for it to return
+func runController(t *testing.T, c *Controller) (cancel func()) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	return func() {
+		cancelCtx()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the controller did not stop")
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		tick    time.Duration
+		jitter  float64
+		wantErr bool
+	}{
+		{name: "valid", tick: 5 * time.Second, jitter: 0.1},
+		{name: "no jitter", tick: 5 * time.Second},
+		{name: "zero poll interval", jitter: 0.1, wantErr: true},
+		{name: "negative poll interval", tick: -time.Second, wantErr: true},
+		{name: "negative jitter", tick: 5 * time.Second, jitter: -0.1, wantErr: true},
+		{name: "jitter of a whole tick", tick: 5 * time.Second, jitter: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.tick, tt.jitter, time.Second)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStageOrderAndIntervals(t *testing.T) {
+	clock := useFakeClock(t)
+	start := clock.Now()
+
+	var lock sync.Mutex
+	runs := []string{}
+
+	stage := func(name string, interval time.Duration) *Stage {
+		return &Stage{
+			Name:     name,
+			Interval: interval,
+			Run: func(ctx context.Context) error {
+				lock.Lock()
+				runs = append(runs, fmt.Sprintf("%s@%s", name, now().Sub(start)))
+				lock.Unlock()
+
+				return nil
+			},
+		}
+	}
+
+	c, err := New(10*time.Second, 0, time.Second,
+		stage("refresh", 30*time.Second), stage("evaluate", 0), stage("act", 20*time.Second))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := runController(t, c)
+
+	for i := 0; i < 4; i++ {
+		clock.waitForWaiter(t)
+		clock.Advance(10 * time.Second)
+	}
+
+	clock.waitForWaiter(t)
+	stop()
+
+	want := []string{
+		"refresh@0s", "evaluate@0s", "act@0s",
+		"evaluate@10s",
+		"evaluate@20s", "act@20s",
+		"refresh@30s", "evaluate@30s",
+		"evaluate@40s", "act@40s",
+	}
+
+	if !reflect.DeepEqual(runs, want) {
+		t.Errorf("got stage runs %v, want %v", runs, want)
+	}
+}
+
+func TestOverrun(t *testing.T) {
+	clock := useFakeClock(t)
+	start := clock.Now()
+
+	started := make(chan string, 10)
+	durations := []time.Duration{15 * time.Second, 4 * time.Second, 0}
+
+	c, err := New(10*time.Second, 0, time.Second, &Stage{
+		Name: "slow",
+		Run: func(ctx context.Context) error {
+			started <- now().Sub(start).String()
+
+			d := durations[0]
+			durations = durations[1:]
+			clock.Advance(d)
+
+			return nil
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := runController(t, c)
+	defer stop()
+
+	// the first iteration overran, the next one starts right away
+	if got := receive(t, started); got != "0s" {
+		t.Errorf("got first iteration at %s, want 0s", got)
+	}
+
+	if got := receive(t, started); got != "15s" {
+		t.Errorf("got second iteration at %s, want 15s", got)
+	}
+
+	// the second iteration took 4s of its 10s tick
+	clock.waitForWaiter(t)
+	clock.Advance(6 * time.Second)
+
+	if got := receive(t, started); got != "25s" {
+		t.Errorf("got third iteration at %s, want 25s", got)
+	}
+}
+
+func TestStageTimeout(t *testing.T) {
+	useFakeClock(t)
+
+	failed := make(chan string, 10)
+	ran := make(chan string, 10)
+
+	c, err := New(10*time.Second, 0, time.Second,
+		&Stage{
+			Name:    "stuck",
+			Timeout: 10 * time.Millisecond,
+			Run: func(ctx context.Context) error {
+				<-ctx.Done()
+				failed <- ctx.Err().Error()
+
+				return ctx.Err()
+			},
+		},
+		&Stage{
+			Name: "next",
+			Run: func(ctx context.Context) error {
+				ran <- "next"
+
+				return nil
+			},
+		},
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := runController(t, c)
+
+	if got := receive(t, failed); got != context.DeadlineExceeded.Error() {
+		t.Errorf("got stage error %q, want %q", got, context.DeadlineExceeded)
+	}
+
+	stop()
+
+	select {
+	case <-ran:
+		t.Error("the stage following the one that timed out ran")
+	default:
+	}
+}
+
+func TestDrainOnCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		// finish is whether the stage in progress finishes within the shutdown timeout
+		finish        bool
+		wantCancelled bool
+	}{
+		{name: "stage finishing within the shutdown timeout", finish: true},
+		{name: "shutdown timeout expiring", wantCancelled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := useFakeClock(t)
+
+			started := make(chan string, 1)
+			release := make(chan struct{})
+			results := make(chan string, 10)
+
+			c, err := New(10*time.Second, 0, 30*time.Second,
+				&Stage{
+					Name: "act",
+					Run: func(ctx context.Context) error {
+						started <- "act"
+
+						select {
+						case <-ctx.Done():
+						case <-release:
+						}
+
+						results <- fmt.Sprintf("act cancelled: %t", ctx.Err() != nil)
+
+						return nil
+					},
+				},
+				&Stage{
+					Name: "next",
+					Run: func(ctx context.Context) error {
+						results <- "next"
+
+						return nil
+					},
+				},
+			)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stop := runController(t, c)
+			receive(t, started)
+
+			stopped := make(chan struct{})
+			go func() {
+				stop()
+				close(stopped)
+			}()
+
+			// the drain timer starts once the controller is cancelled
+			clock.waitForWaiter(t)
+
+			if tt.finish {
+				clock.Advance(29 * time.Second)
+				close(release)
+			} else {
+				clock.Advance(30 * time.Second)
+			}
+
+			<-stopped
+
+			want := fmt.Sprintf("act cancelled: %t", tt.wantCancelled)
+			if got := receive(t, results); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+
+			select {
+			case r := <-results:
+				t.Errorf("got %q after the controller was stopped", r)
+			default:
+			}
+		})
+	}
+}
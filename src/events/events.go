@@ -0,0 +1,25 @@
+package events
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ServiceAppeared is emitted when a service shows up in the swarm cluster
+	ServiceAppeared = "service_appeared"
+	// ServiceDisappeared is emitted when a service is removed from the swarm cluster
+	ServiceDisappeared = "service_disappeared"
+	// NodeAppeared is emitted when a node becomes ready and active
+	NodeAppeared = "node_appeared"
+	// NodeDisappeared is emitted when a node goes down, is drained or paused, or leaves the swarm cluster
+	NodeDisappeared = "node_disappeared"
+	// TaskAppeared is emitted when a task starts running
+	TaskAppeared = "task_appeared"
+	// TaskDisappeared is emitted when a task stops running
+	TaskDisappeared = "task_disappeared"
+)
+
+// Emit logs an event that happened in the swarm cluster
+func Emit(eventType string, fields log.Fields) {
+	log.WithFields(fields).WithField("event", eventType).Info(eventType)
+}
@@ -0,0 +1,44 @@
+package fakeswarm
+
+import (
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// NewDemo creates a Swarm with one manager and four workers running the services of the README example
+//
+// portainer is a global mode service constrained on the portainer node label, which two workers carry, and api is a
+// replicated mode service with two replicas
+func NewDemo() *Swarm {
+	s := New()
+
+	s.AddNode("manager-1", swarm.NodeRoleManager, nil)
+	s.AddNode("worker-1", swarm.NodeRoleWorker, map[string]string{"portainer": "1"})
+	s.AddNode("worker-2", swarm.NodeRoleWorker, map[string]string{"portainer": "1"})
+	s.AddNode("worker-3", swarm.NodeRoleWorker, nil)
+	s.AddNode("worker-4", swarm.NodeRoleWorker, nil)
+
+	portainer := s.AddService(swarm.ServiceSpec{
+		Annotations: swarm.Annotations{Name: "portainer"},
+		TaskTemplate: swarm.TaskSpec{
+			ContainerSpec: swarm.ContainerSpec{Image: "portainer/portainer:latest"},
+			Placement:     &swarm.Placement{Constraints: []string{"node.labels.portainer == 1"}},
+		},
+		Mode: swarm.ServiceMode{Global: &swarm.GlobalService{}},
+	})
+	s.SetServiceUsage(portainer, Usage{CPU: 35, Memory: 40})
+
+	replicas := uint64(2)
+	api := s.AddService(swarm.ServiceSpec{
+		Annotations: swarm.Annotations{Name: "api"},
+		TaskTemplate: swarm.TaskSpec{
+			ContainerSpec: swarm.ContainerSpec{Image: "nginx:alpine"},
+			Resources: &swarm.ResourceRequirements{
+				Limits: &swarm.Resources{NanoCPUs: 5e8},
+			},
+		},
+		Mode: swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+	})
+	s.SetServiceUsage(api, Usage{CPU: 10, Memory: 20})
+
+	return s
+}
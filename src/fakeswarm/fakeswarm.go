@@ -0,0 +1,790 @@
+package fakeswarm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+
+	"../constraint"
+	"../types"
+)
+
+// Usage is the cpu and memory usage, as percentages, reported in the stats of a simulated container
+type Usage struct {
+	CPU    float64
+	Memory float64
+}
+
+// Swarm is an in-memory swarm cluster that implements client.SwarmAPI
+//
+// Its orchestrator runs synchronously after every change: global mode services get a running task on every ready and
+// active node matching their constraints and replicated mode services get their replica count of running tasks spread
+// over those nodes
+type Swarm struct {
+	lock          sync.Mutex
+	lastID        int
+	nodes         map[string]*swarm.Node
+	services      map[string]*swarm.Service
+	tasks         map[string]*swarm.Task
+	serviceUsages map[string]Usage
+	taskUsages    map[string]Usage
+	// rawSpecs are the specs of the services as they were last set, including the settings swarm.ServiceSpec does
+	// not know about
+	rawSpecs map[string]json.RawMessage
+	// nodeUpdateHook is called before every node update and fails it by returning an error
+	nodeUpdateHook func(ctx context.Context, nodeID string) error
+	// failures are the errors returned by the api methods set to fail, by method name
+	failures map[string]error
+	// statsFailures are the errors returned when fetching the stats of the containers of some tasks, by task ID
+	statsFailures map[string]error
+}
+
+// containerMemoryLimit is the memory limit reported in the stats of every simulated container
+const containerMemoryLimit = 1 << 30
+
+// New creates an empty Swarm
+func New() *Swarm {
+	return &Swarm{
+		nodes:         map[string]*swarm.Node{},
+		services:      map[string]*swarm.Service{},
+		rawSpecs:      map[string]json.RawMessage{},
+		tasks:         map[string]*swarm.Task{},
+		serviceUsages: map[string]Usage{},
+		taskUsages:    map[string]Usage{},
+		failures:      map[string]error{},
+		statsFailures: map[string]error{},
+	}
+}
+
+// AddNode adds a ready and active node with 2 cpus and 4GiB of memory and returns its ID
+func (s *Swarm) AddNode(hostname string, role swarm.NodeRole, labels map[string]string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := s.newID("node")
+
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	node := &swarm.Node{
+		ID:   id,
+		Meta: s.newMeta(),
+		Spec: swarm.NodeSpec{
+			Annotations:  swarm.Annotations{Labels: labels},
+			Role:         role,
+			Availability: swarm.NodeAvailabilityActive,
+		},
+		Description: swarm.NodeDescription{
+			Hostname: hostname,
+			Platform: swarm.Platform{
+				OS:           "linux",
+				Architecture: "x86_64",
+			},
+			Resources: swarm.Resources{
+				NanoCPUs:    2e9,
+				MemoryBytes: 4 << 30,
+			},
+			Engine: swarm.EngineDescription{
+				EngineVersion: "17.06.0-ce",
+			},
+		},
+		Status: swarm.NodeStatus{
+			State: swarm.NodeStateReady,
+			Addr:  fmt.Sprintf("10.0.0.%d", len(s.nodes)+1),
+		},
+	}
+
+	if role == swarm.NodeRoleManager {
+		node.ManagerStatus = &swarm.ManagerStatus{
+			Leader:       len(s.nodes) == 0,
+			Reachability: swarm.ReachabilityReachable,
+		}
+	}
+
+	s.nodes[id] = node
+	s.orchestrate()
+
+	return id
+}
+
+// SetNodeState changes the state of a node, e.g. to simulate it going down
+func (s *Swarm) SetNodeState(nodeID string, state swarm.NodeState) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if n, ok := s.nodes[nodeID]; ok {
+		n.Status.State = state
+		s.orchestrate()
+	}
+}
+
+// SetNodeAvailability changes the availability of a node, e.g. to simulate draining it
+func (s *Swarm) SetNodeAvailability(nodeID string, availability swarm.NodeAvailability) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if n, ok := s.nodes[nodeID]; ok {
+		n.Spec.Availability = availability
+		n.Version.Index++
+		s.orchestrate()
+	}
+}
+
+// SetNodeUpdateHook sets a function called before every node update whose error fails the update, e.g. to simulate a
+// node that cannot be labeled
+func (s *Swarm) SetNodeUpdateHook(hook func(ctx context.Context, nodeID string) error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.nodeUpdateHook = hook
+}
+
+// SetFailure makes every call to the api method with the given name, like NodeList, fail with err until it is set
+// again with a nil err
+func (s *Swarm) SetFailure(method string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err == nil {
+		delete(s.failures, method)
+	} else {
+		s.failures[method] = err
+	}
+}
+
+// AddService creates a service from spec and returns its ID
+func (s *Swarm) AddService(spec swarm.ServiceSpec) string {
+	data, err := json.Marshal(spec)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return s.AddRawService(string(data))
+}
+
+// AddRawService creates a service from the json of its spec, which may hold settings of newer docker versions that
+// swarm.ServiceSpec does not know about, and returns its ID
+func (s *Swarm) AddRawService(spec string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := s.newID("service")
+
+	svc := &swarm.Service{
+		ID:   id,
+		Meta: s.newMeta(),
+	}
+
+	if err := json.Unmarshal([]byte(spec), &svc.Spec); err != nil {
+		panic(err)
+	}
+
+	s.services[id] = svc
+	s.rawSpecs[id] = json.RawMessage(spec)
+	s.orchestrate()
+
+	return id
+}
+
+// RemoveService removes a service and stops its tasks
+func (s *Swarm) RemoveService(serviceID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.services[serviceID]; !ok {
+		return
+	}
+
+	for _, t := range s.tasks {
+		if t.ServiceID == serviceID && t.Status.State == swarm.TaskStateRunning {
+			s.stopTask(t)
+		}
+	}
+
+	delete(s.services, serviceID)
+	delete(s.rawSpecs, serviceID)
+	delete(s.serviceUsages, serviceID)
+}
+
+// SetServiceUsage sets the usage reported by the containers of a service, including the ones started later
+func (s *Swarm) SetServiceUsage(serviceID string, usage Usage) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.serviceUsages[serviceID] = usage
+
+	for _, t := range s.tasks {
+		if t.ServiceID == serviceID {
+			delete(s.taskUsages, t.ID)
+		}
+	}
+}
+
+// SetTaskUsage sets the usage reported by the container of a single task, overriding the usage of its service
+func (s *Swarm) SetTaskUsage(taskID string, usage Usage) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.taskUsages[taskID] = usage
+}
+
+// SetTaskStatsFailure makes fetching the stats of the container of a task fail with err, or succeed again if err is
+// nil
+func (s *Swarm) SetTaskStatsFailure(taskID string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err == nil {
+		delete(s.statsFailures, taskID)
+	} else {
+		s.statsFailures[taskID] = err
+	}
+}
+
+// Node returns a copy of a node
+func (s *Swarm) Node(nodeID string) (swarm.Node, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var result swarm.Node
+
+	n, ok := s.nodes[nodeID]
+
+	if ok {
+		deepCopy(n, &result)
+	}
+
+	return result, ok
+}
+
+// Service returns a copy of a service
+func (s *Swarm) Service(serviceID string) (swarm.Service, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var result swarm.Service
+
+	svc, ok := s.services[serviceID]
+
+	if ok {
+		deepCopy(svc, &result)
+	}
+
+	return result, ok
+}
+
+// RunningTasks returns copies of the running tasks of a service ordered by their ID
+func (s *Swarm) RunningTasks(serviceID string) []swarm.Task {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tasks := []swarm.Task{}
+
+	for _, t := range s.sortedTasks() {
+		if t.ServiceID == serviceID && t.Status.State == swarm.TaskStateRunning {
+			var task swarm.Task
+			deepCopy(t, &task)
+			tasks = append(tasks, task)
+		}
+	}
+
+	return tasks
+}
+
+// SwarmInspect returns the swarm cluster information
+func (s *Swarm) SwarmInspect(ctx context.Context) (swarm.Swarm, error) {
+	if err := s.failure(ctx, "SwarmInspect"); err != nil {
+		return swarm.Swarm{}, err
+	}
+
+	return swarm.Swarm{ClusterInfo: swarm.ClusterInfo{ID: "fakeswarm"}}, nil
+}
+
+// ServiceListWithRaw returns all the services and their json representation, holding their specs as they were last
+// set
+func (s *Swarm) ServiceListWithRaw(ctx context.Context) ([]swarm.Service, []byte, error) {
+	if err := s.failure(ctx, "ServiceListWithRaw"); err != nil {
+		return nil, nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	raw := []map[string]json.RawMessage{}
+	for _, svc := range s.sortedServices() {
+		var r map[string]json.RawMessage
+		deepCopy(svc, &r)
+		r["Spec"] = s.rawSpecs[svc.ID]
+		raw = append(raw, r)
+	}
+
+	services := []swarm.Service{}
+
+	return services, deepCopy(raw, &services), nil
+}
+
+// ServiceInspectWithRaw returns a service and its json representation, holding its spec as it was last set
+func (s *Swarm) ServiceInspectWithRaw(ctx context.Context, serviceID string) (swarm.Service, []byte, error) {
+	var result swarm.Service
+
+	if err := s.failure(ctx, "ServiceInspectWithRaw"); err != nil {
+		return result, nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	svc, ok := s.services[serviceID]
+
+	if !ok {
+		return result, nil, fmt.Errorf("Error: no such service: %s", serviceID)
+	}
+
+	var raw map[string]json.RawMessage
+	deepCopy(svc, &raw)
+	raw["Spec"] = s.rawSpecs[serviceID]
+
+	return result, deepCopy(raw, &result), nil
+}
+
+// ServiceUpdateRaw replaces the spec of a service with the json spec if version is its current version
+func (s *Swarm) ServiceUpdateRaw(ctx context.Context, serviceID string, version swarm.Version,
+	spec json.RawMessage) error {
+	if err := s.failure(ctx, "ServiceUpdateRaw"); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	svc, ok := s.services[serviceID]
+
+	if !ok {
+		return fmt.Errorf("Error: no such service: %s", serviceID)
+	}
+
+	if svc.Version.Index != version.Index {
+		return fmt.Errorf("rpc error: code = 2 desc = update out of sequence")
+	}
+
+	var newSpec swarm.ServiceSpec
+
+	if err := json.Unmarshal(spec, &newSpec); err != nil {
+		return fmt.Errorf("Error response from daemon: invalid spec: %s", err)
+	}
+
+	svc.Spec = newSpec
+	s.rawSpecs[serviceID] = append(json.RawMessage{}, spec...)
+	svc.Version.Index++
+	svc.UpdatedAt = time.Now()
+	s.orchestrate()
+
+	return nil
+}
+
+// NodeList returns all the nodes, ignoring any filters in options
+func (s *Swarm) NodeList(ctx context.Context, options dockerTypes.NodeListOptions) ([]swarm.Node, error) {
+	if err := s.failure(ctx, "NodeList"); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	nodes := []swarm.Node{}
+	deepCopy(s.sortedNodes(), &nodes)
+
+	return nodes, nil
+}
+
+// NodeInspectWithRaw returns a node and its json representation
+func (s *Swarm) NodeInspectWithRaw(ctx context.Context, nodeID string) (swarm.Node, []byte, error) {
+	var result swarm.Node
+
+	if err := s.failure(ctx, "NodeInspectWithRaw"); err != nil {
+		return result, nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	n, ok := s.nodes[nodeID]
+
+	if !ok {
+		return result, nil, fmt.Errorf("Error: No such node: %s", nodeID)
+	}
+
+	raw := deepCopy(n, &result)
+
+	return result, raw, nil
+}
+
+// NodeUpdate replaces the spec of a node if version is its current version
+func (s *Swarm) NodeUpdate(ctx context.Context, nodeID string, version swarm.Version, node swarm.NodeSpec) error {
+	if err := s.failure(ctx, "NodeUpdate"); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	hook := s.nodeUpdateHook
+	s.lock.Unlock()
+
+	if hook != nil {
+		if err := hook(ctx, nodeID); err != nil {
+			return err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	n, ok := s.nodes[nodeID]
+
+	if !ok {
+		return fmt.Errorf("Error: No such node: %s", nodeID)
+	}
+
+	if n.Version.Index != version.Index {
+		return fmt.Errorf("rpc error: code = 2 desc = update out of sequence")
+	}
+
+	n.Spec = swarm.NodeSpec{}
+	deepCopy(node, &n.Spec)
+	n.Version.Index++
+	n.UpdatedAt = time.Now()
+	s.orchestrate()
+
+	return nil
+}
+
+// TaskList returns all the tasks, including the ones that are no longer running, ignoring any filters in options
+func (s *Swarm) TaskList(ctx context.Context, options dockerTypes.TaskListOptions) ([]swarm.Task, error) {
+	if err := s.failure(ctx, "TaskList"); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tasks := []swarm.Task{}
+	deepCopy(s.sortedTasks(), &tasks)
+
+	return tasks, nil
+}
+
+// ContainerStats returns a single stats sample of the container of a running task, regardless of stream
+func (s *Swarm) ContainerStats(ctx context.Context, containerID string, stream bool) (dockerTypes.ContainerStats, error) {
+	var result dockerTypes.ContainerStats
+
+	if err := s.failure(ctx, "ContainerStats"); err != nil {
+		return result, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var task *swarm.Task
+	for _, t := range s.tasks {
+		if t.Status.ContainerStatus.ContainerID == containerID && t.Status.State == swarm.TaskStateRunning {
+			task = t
+
+			break
+		}
+	}
+
+	if task == nil {
+		return result, fmt.Errorf("Error: No such container: %s", containerID)
+	}
+
+	if err := s.statsFailures[task.ID]; err != nil {
+		return result, err
+	}
+
+	usage, ok := s.taskUsages[task.ID]
+
+	if !ok {
+		usage = s.serviceUsages[task.ServiceID]
+	}
+
+	body, err := json.Marshal(newContainerStatsRaw(containerID, usage))
+
+	if err != nil {
+		return result, err
+	}
+
+	result.Body = ioutil.NopCloser(bytes.NewReader(body))
+	result.OSType = "linux"
+
+	return result, nil
+}
+
+// failure returns the error a call to an api method fails with, either because ctx is done or because the method was
+// set to fail
+func (s *Swarm) failure(ctx context.Context, method string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.failures[method]
+}
+
+// newContainerStatsRaw builds a stats sample that yields usage, for a container seeing a single cpu
+func newContainerStatsRaw(containerID string, usage Usage) types.ContainerStatsRaw {
+	var stats types.ContainerStatsRaw
+
+	stats.ID = containerID
+	stats.PreCPUStats.CPUUsage.TotalUsage = 1e9
+	stats.PreCPUStats.SystemCPUUsage = 1e10
+	stats.PreCPUStats.OnlineCPUs = 1
+	stats.CPUStats.CPUUsage.TotalUsage = 1e9 + int64(usage.CPU/100.0*1e9)
+	stats.CPUStats.SystemCPUUsage = 1e10 + 1e9
+	stats.CPUStats.OnlineCPUs = 1
+	stats.MemoryStats.Limit = containerMemoryLimit
+	stats.MemoryStats.Usage = int64(usage.Memory / 100.0 * containerMemoryLimit)
+
+	return stats
+}
+
+// orchestrate starts and stops tasks so that every service runs the tasks its spec asks for
+func (s *Swarm) orchestrate() {
+	for _, svc := range s.sortedServices() {
+		eligibleNodes := map[string]bool{}
+		for _, n := range s.sortedNodes() {
+			if n.Status.State == swarm.NodeStateReady && n.Spec.Availability == swarm.NodeAvailabilityActive &&
+				matchesPlacement(n, svc.Spec.TaskTemplate.Placement, s.rawSpecs[svc.ID]) {
+				eligibleNodes[n.ID] = true
+			}
+		}
+
+		tasksPerNode := map[string]int{}
+		running := []*swarm.Task{}
+
+		for _, t := range s.sortedTasks() {
+			if t.ServiceID != svc.ID || t.Status.State != swarm.TaskStateRunning {
+				continue
+			}
+
+			if !eligibleNodes[t.NodeID] {
+				s.stopTask(t)
+
+				continue
+			}
+
+			tasksPerNode[t.NodeID]++
+			running = append(running, t)
+		}
+
+		if svc.Spec.Mode.Global != nil {
+			for _, n := range s.sortedNodes() {
+				if eligibleNodes[n.ID] && tasksPerNode[n.ID] == 0 {
+					s.startTask(svc, n.ID, 0)
+				}
+			}
+
+			continue
+		}
+
+		replicas := 1
+		if r := svc.Spec.Mode.Replicated; r != nil && r.Replicas != nil {
+			replicas = int(*r.Replicas)
+		}
+
+		// the newest tasks are stopped first
+		for len(running) > replicas {
+			s.stopTask(running[len(running)-1])
+			running = running[:len(running)-1]
+		}
+
+		if len(eligibleNodes) == 0 {
+			continue
+		}
+
+		for slot := len(running) + 1; slot <= replicas; slot++ {
+			nodeID := leastUsedNode(eligibleNodes, tasksPerNode)
+			s.startTask(svc, nodeID, slot)
+			tasksPerNode[nodeID]++
+		}
+	}
+}
+
+// startTask
+func (s *Swarm) startTask(svc *swarm.Service, nodeID string, slot int) {
+	id := s.newID("task")
+
+	task := &swarm.Task{
+		ID:        id,
+		Meta:      s.newMeta(),
+		ServiceID: svc.ID,
+		Slot:      slot,
+		NodeID:    nodeID,
+		Status: swarm.TaskStatus{
+			Timestamp: time.Now(),
+			State:     swarm.TaskStateRunning,
+			ContainerStatus: swarm.ContainerStatus{
+				ContainerID: "container-" + id,
+			},
+		},
+		DesiredState: swarm.TaskStateRunning,
+	}
+	deepCopy(svc.Spec.TaskTemplate, &task.Spec)
+
+	s.tasks[id] = task
+}
+
+// stopTask
+func (s *Swarm) stopTask(t *swarm.Task) {
+	t.Status.State = swarm.TaskStateShutdown
+	t.Status.Timestamp = time.Now()
+	t.DesiredState = swarm.TaskStateShutdown
+	t.Version.Index++
+}
+
+// newID returns a readable ID that is unique within the swarm, like node-1 or task-12
+func (s *Swarm) newID(kind string) string {
+	s.lastID++
+
+	return fmt.Sprintf("%s-%d", kind, s.lastID)
+}
+
+// newMeta
+func (s *Swarm) newMeta() swarm.Meta {
+	now := time.Now()
+
+	return swarm.Meta{
+		Version:   swarm.Version{Index: 1},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// sortedNodes
+func (s *Swarm) sortedNodes() []*swarm.Node {
+	nodes := make([]*swarm.Node, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		nodes = append(nodes, n)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return idLess(nodes[i].ID, nodes[j].ID) })
+
+	return nodes
+}
+
+// sortedServices
+func (s *Swarm) sortedServices() []*swarm.Service {
+	services := make([]*swarm.Service, 0, len(s.services))
+	for _, svc := range s.services {
+		services = append(services, svc)
+	}
+
+	sort.Slice(services, func(i, j int) bool { return idLess(services[i].ID, services[j].ID) })
+
+	return services
+}
+
+// sortedTasks
+func (s *Swarm) sortedTasks() []*swarm.Task {
+	tasks := make([]*swarm.Task, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		tasks = append(tasks, t)
+	}
+
+	sort.Slice(tasks, func(i, j int) bool { return idLess(tasks[i].ID, tasks[j].ID) })
+
+	return tasks
+}
+
+// idLess orders IDs created by newID by creation time
+func idLess(id1 string, id2 string) bool {
+	if len(id1) != len(id2) {
+		return len(id1) < len(id2)
+	}
+
+	return id1 < id2
+}
+
+// leastUsedNode returns the eligible node running the fewest tasks, preferring the one with the lowest ID on ties
+func leastUsedNode(eligibleNodes map[string]bool, tasksPerNode map[string]int) string {
+	var result string
+
+	for id := range eligibleNodes {
+		if result == "" || tasksPerNode[id] < tasksPerNode[result] ||
+			(tasksPerNode[id] == tasksPerNode[result] && idLess(id, result)) {
+			result = id
+		}
+	}
+
+	return result
+}
+
+// matchesPlacement evaluates the constraints of a placement and the platforms of the raw spec of a service against a
+// node, an invalid constraint matching no node as the docker scheduler would never place the task
+func matchesPlacement(n *swarm.Node, placement *swarm.Placement, rawSpec json.RawMessage) bool {
+	var spec struct {
+		TaskTemplate struct {
+			Placement struct {
+				Platforms []types.Platform
+			}
+		}
+	}
+
+	if err := json.Unmarshal(rawSpec, &spec); err != nil {
+		return false
+	}
+
+	node := types.Node{
+		ID:           n.ID,
+		Hostname:     n.Description.Hostname,
+		Role:         string(n.Spec.Role),
+		Labels:       n.Spec.Labels,
+		EngineLabels: n.Description.Engine.Labels,
+		Platform: types.Platform{
+			OS:           n.Description.Platform.OS,
+			Architecture: n.Description.Platform.Architecture,
+		},
+	}
+
+	if !constraint.MatchPlatforms(spec.TaskTemplate.Placement.Platforms, node) {
+		return false
+	}
+
+	if placement == nil {
+		return true
+	}
+
+	constraints, err := constraint.ParseAll(placement.Constraints)
+
+	if err != nil {
+		return false
+	}
+
+	return constraint.MatchAll(constraints, node)
+}
+
+// deepCopy copies src into dst through their json representation, so that callers never share maps or slices with
+// the swarm, and returns that representation
+func deepCopy(src interface{}, dst interface{}) []byte {
+	data, err := json.Marshal(src)
+
+	if err != nil {
+		panic(err)
+	}
+
+	if err := json.Unmarshal(data, dst); err != nil {
+		panic(err)
+	}
+
+	return data
+}
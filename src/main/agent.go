@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"../agent"
+)
+
+// agentFlags
+func agentFlags(flags *flag.FlagSet, opts *options) {
+	flags.StringVar(&opts.listenAddr, "listen", ":9324", "address the agent http api listens on")
+	flags.StringVar(&opts.procRoot, "proc-root", "/proc", "mount point of the proc filesystem of the host")
+	flags.StringVar(&opts.cgroupRoot, "cgroup-root", "/sys/fs/cgroup", "mount point of the cgroup hierarchy of the host")
+	flags.StringVar(&opts.tlsCert, "tls-cert", "", "certificate to serve the agent http api over https with")
+	flags.StringVar(&opts.tlsKey, "tls-key", "", "private key of the -tls-cert certificate")
+}
+
+// agentCommand
+func agentCommand(opts options) error {
+	if (opts.tlsCert == "") != (opts.tlsKey == "") {
+		return fmt.Errorf("the -tls-cert and -tls-key flags must be set together")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		sigTERM := make(chan os.Signal, 1)
+		signal.Notify(sigTERM, syscall.SIGTERM, syscall.SIGINT)
+		defer signal.Stop(sigTERM)
+
+		log.Infof("stopping agent on %s", <-sigTERM)
+		cancel()
+	}()
+
+	return agent.New(opts.procRoot, opts.cgroupRoot).Serve(ctx, opts.listenAddr, opts.tlsCert, opts.tlsKey)
+}
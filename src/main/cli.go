@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../client"
+	"../cluster"
+	"../config"
+	"../fakeswarm"
+	"../service"
+)
+
+// fakeSwarmScheme is the -docker-host scheme that selects the in-memory demo swarm
+const fakeSwarmScheme = "fake://"
+
+// version is set at build time through -ldflags "-X main.version=..."
+var version = "dev"
+
+// options holds the flags shared by all the subcommands
+type options struct {
+	configPath      string
+	logFile         string
+	logLevel        string
+	logFormat       string
+	pollInterval    time.Duration
+	refreshInterval time.Duration
+	scaleInterval   time.Duration
+	stageTimeout    time.Duration
+	shutdownTimeout time.Duration
+	jitter          float64
+	dockerHost      string
+	statsWorkers    int
+
+	// the flags of the run command only
+	stateStore string
+
+	// the flags of the agent command only
+	listenAddr string
+	procRoot   string
+	cgroupRoot string
+	tlsCert    string
+	tlsKey     string
+}
+
+// command is a subcommand of the docker-service-autoscaler binary
+type command struct {
+	name        string
+	description string
+	run         func(opts options) error
+	// flags registers the flags specific to the command, if any
+	flags func(flags *flag.FlagSet, opts *options)
+}
+
+var commands = []command{
+	{"run", "run the autoscaler daemon", runCommand, runFlags},
+	{"validate-config", "validate the configuration file and exit", validateConfigCommand, nil},
+	{"status", "print the current state of the configured services and exit", statusCommand, nil},
+	{"dry-run", "evaluate the scaling once, ignoring periods and cooldowns, and exit", dryRunCommand, nil},
+	{"agent", "serve the resource usage of this node and its containers over http", agentCommand, agentFlags},
+	{"version", "print the version and exit", versionCommand, nil},
+}
+
+// parseCommandLine finds the subcommand named in args and parses its flags
+func parseCommandLine(args []string) (command, options) {
+	var opts options
+
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == args[0] {
+			cmd = &commands[i]
+
+			break
+		}
+	}
+
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		usage()
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	flags.StringVar(&opts.configPath, "config", "", "path to the json or yaml configuration file")
+	flags.StringVar(&opts.logFile, "log-file", "-", "path to the log file or - for stderr")
+	flags.StringVar(&opts.logLevel, "log-level", "info", "log level (debug, info, warning, error)")
+	flags.StringVar(&opts.logFormat, "log-format", "json", "log format (json or text)")
+	flags.DurationVar(&opts.pollInterval, "poll-interval", 5*time.Second, "interval between two reconcile iterations")
+	flags.DurationVar(&opts.refreshInterval, "refresh-interval", 0,
+		"minimum interval between two cluster state refreshes, 0 refreshes on every iteration")
+	flags.DurationVar(&opts.scaleInterval, "scale-interval", 0,
+		"minimum interval between two scaling evaluations, 0 evaluates on every iteration")
+	flags.DurationVar(&opts.stageTimeout, "stage-timeout", 30*time.Second, "maximum duration of a single reconcile stage")
+	flags.DurationVar(&opts.shutdownTimeout, "shutdown-timeout", 30*time.Second,
+		"time given to in-flight scaling operations to finish on shutdown")
+	flags.Float64Var(&opts.jitter, "jitter", 0.1,
+		"fraction of the poll interval by which each iteration is randomly shifted")
+	flags.IntVar(&opts.statsWorkers, "stats-workers", 8,
+		"maximum number of container stats requests made at the same time")
+	flags.StringVar(&opts.dockerHost, "docker-host", "", "docker daemon socket to connect to, defaults to the "+
+		"DOCKER_HOST environment variable, "+fakeSwarmScheme+" uses an in-memory demo swarm")
+
+	if cmd.flags != nil {
+		cmd.flags(flags, &opts)
+	}
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: docker-service-autoscaler %s [flags]\n\n%s\n\nflags:\n", cmd.name, cmd.description)
+		flags.PrintDefaults()
+	}
+
+	// flag.ExitOnError makes Parse exit on invalid flags
+	_ = flags.Parse(args[1:])
+
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n\n", strings.Join(flags.Args(), " "))
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	return *cmd, opts
+}
+
+// usage prints the list of available subcommands
+func usage() {
+	fmt.Fprint(os.Stderr, "usage: docker-service-autoscaler <command> [flags]\n\ncommands:\n")
+
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", c.name, c.description)
+	}
+
+	fmt.Fprint(os.Stderr, "\nrun docker-service-autoscaler <command> -h for the flags of a command\n")
+}
+
+// setupLogging configures the logger from the log flags and returns a function that releases the log file
+func setupLogging(opts options) (func(), error) {
+	level, err := log.ParseLevel(opts.logLevel)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.SetLevel(level)
+
+	switch opts.logFormat {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	case "text":
+		log.SetFormatter(&log.TextFormatter{})
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.logFormat)
+	}
+
+	if opts.logFile == "-" {
+		return func() {}, nil
+	}
+
+	if stat, err := os.Stat(opts.logFile); err == nil && stat.IsDir() {
+		return nil, fmt.Errorf("cannot log to directory %s", opts.logFile)
+	}
+
+	logFile, err := os.OpenFile(opts.logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot log to file %s: %s", opts.logFile, err)
+	}
+
+	log.SetOutput(logFile)
+
+	return func() { logFile.Close() }, nil
+}
+
+// requireConfig fails if the config flag was not given
+func requireConfig(opts options) error {
+	if opts.configPath == "" {
+		return fmt.Errorf("the -config flag is required")
+	}
+
+	return nil
+}
+
+// loadConfigAndState loads the configuration and refreshes the cluster state once, as needed by the one-off commands
+func loadConfigAndState(ctx context.Context, opts options) error {
+	if err := requireConfig(opts); err != nil {
+		return err
+	}
+
+	if err := service.UpdateConfig(opts.configPath); err != nil {
+		return err
+	}
+
+	if err := connect(ctx, opts); err != nil {
+		return err
+	}
+
+	if err := cluster.UpdateState(ctx); err != nil {
+		return err
+	}
+
+	service.CollectStats(ctx)
+
+	return nil
+}
+
+// connect sets up the client of the cluster package, using an in-memory demo swarm if the docker host starts with
+// fake://
+func connect(ctx context.Context, opts options) error {
+	var api client.SwarmAPI
+
+	if strings.HasPrefix(opts.dockerHost, fakeSwarmScheme) {
+		log.Warn("using an in-memory demo swarm instead of a docker engine")
+
+		api = fakeswarm.NewDemo()
+	} else {
+		dockerAPI, err := client.NewDockerAPI(opts.dockerHost)
+
+		if err != nil {
+			return err
+		}
+
+		api = dockerAPI
+	}
+
+	c := client.New(api)
+
+	if err := c.CheckSwarm(ctx); err != nil {
+		return err
+	}
+
+	cluster.SetClient(c, opts.statsWorkers)
+
+	return nil
+}
+
+// validateConfigCommand
+func validateConfigCommand(opts options) error {
+	if err := requireConfig(opts); err != nil {
+		return err
+	}
+
+	servicesConfig, err := config.Load(opts.configPath)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is valid and configures %d services\n", opts.configPath, len(servicesConfig.Services))
+
+	return nil
+}
+
+// statusCommand
+func statusCommand(opts options) error {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.stageTimeout)
+	defer cancel()
+
+	if err := loadConfigAndState(ctx, opts); err != nil {
+		return err
+	}
+
+	output, err := json.MarshalIndent(service.GetServiceStates(ctx), "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(output))
+
+	return nil
+}
+
+// dryRunCommand
+func dryRunCommand(opts options) error {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.stageTimeout)
+	defer cancel()
+
+	if err := loadConfigAndState(ctx, opts); err != nil {
+		return err
+	}
+
+	output, err := json.MarshalIndent(service.DryRunServices(ctx), "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(output))
+
+	return nil
+}
+
+// versionCommand
+func versionCommand(opts options) error {
+	fmt.Printf("docker-service-autoscaler %s\n", version)
+
+	return nil
+}
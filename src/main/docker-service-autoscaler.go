@@ -1,7 +1,9 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -10,81 +12,209 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"../cluster"
+	"../config"
+	"../controller"
 	"../service"
+	"../statestore"
 )
 
-func init() {
+const configWatchInterval = 2 * time.Second
 
+func main() {
+	cmd, opts := parseCommandLine(os.Args[1:])
+
+	if err := runLogged(cmd, opts); err != nil {
+		os.Exit(1)
+	}
 }
 
-func main() {
-	log.SetFormatter(&log.JSONFormatter{})
-	log.SetLevel(log.DebugLevel)
+// runLogged runs cmd with the logger configured from opts, logging the error it fails with before the log file is
+// released
+func runLogged(cmd command, opts options) error {
+	closeLog, err := setupLogging(opts)
 
-	configNotProvided := len(os.Args) < 3
+	if err != nil {
+		log.Error(err)
 
-	if !configNotProvided {
-		if stat, err := os.Stat(os.Args[1]); os.IsPermission(err) || os.IsNotExist(err) || stat.IsDir() {
-			configNotProvided = true
-		}
+		return err
 	}
 
-	/*if configNotProvided { // TODO: uncomment
-		log.Fatal("usage: docker-service-autoscaler \"/path/to/json/config/file\" \"/path/to/log/file\"\n")
+	defer closeLog()
+
+	if err := cmd.run(opts); err != nil {
+		log.Error(err)
+
+		return err
 	}
 
-	if os.Args[2] != "-" {
-		if stat, err := os.Stat(os.Args[2]); os.IsPermission(err) || stat.IsDir() {
-			log.Fatalf("cannot log to directory %s", os.Args[2])
-		}
+	return nil
+}
+
+// runFlags
+func runFlags(flags *flag.FlagSet, opts *options) {
+	flags.StringVar(&opts.stateStore, "state-store", "", "where to keep the staged scalings and cooldowns across "+
+		"restarts: file:<path> or service-label, nothing is kept by default")
+}
+
+// runCommand
+func runCommand(opts options) error {
+	if err := requireConfig(opts); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// decisions are handed from the evaluate stage to the act stage of the same iteration
+	var decisions, abandoned []service.ScalingDecision
+
+	c, err := controller.New(opts.pollInterval, opts.jitter, opts.shutdownTimeout,
+		&controller.Stage{
+			Name:     "refresh",
+			Interval: opts.refreshInterval,
+			Timeout:  opts.stageTimeout,
+			Run:      cluster.UpdateState,
+		},
+		&controller.Stage{
+			Name:     "collect",
+			Interval: opts.refreshInterval,
+			Timeout:  opts.stageTimeout,
+			Run: func(ctx context.Context) error {
+				service.CollectStats(ctx)
+
+				return nil
+			},
+		},
+		&controller.Stage{
+			Name:     "evaluate",
+			Interval: opts.scaleInterval,
+			Timeout:  opts.stageTimeout,
+			Run: func(ctx context.Context) error {
+				decisions = service.EvaluateServices(ctx)
+				service.SaveScalingState(ctx)
+
+				return nil
+			},
+		},
+		&controller.Stage{
+			Name:     "act",
+			Interval: opts.scaleInterval,
+			Timeout:  opts.stageTimeout,
+			Run: func(stageCtx context.Context) error {
+				interrupted := service.ApplyDecisions(stageCtx, decisions)
+				decisions = nil
+				service.SaveScalingState(stageCtx)
+
+				// decisions interrupted by the stage timeout were already logged, only the ones the shutdown
+				// interrupted are reported on exit
+				if ctx.Err() != nil {
+					abandoned = interrupted
+				}
+
+				return nil
+			},
+		},
+	)
+
+	if err != nil {
+		return err
+	}
 
-		logFile, err := os.OpenFile(os.Args[2], os.O_WRONLY|os.O_CREATE, 0755)
+	var store statestore.Store
 
-		if err != nil {
-			log.Fatalf("cannot log to file %s: %s", os.Args[2], err)
+	if opts.stateStore != "" {
+		if store, err = statestore.New(opts.stateStore); err != nil {
+			return err
 		}
+	}
 
-		defer logFile.Close()
+	if err := service.UpdateConfig(opts.configPath); err != nil {
+		return err
+	}
 
-		log.SetOutput(logFile)
-	}*/
+	if err := connect(ctx, opts); err != nil {
+		return err
+	}
 
-	for {
-		mainRecovering( /*os.Args[1]*/ "") // TODO: uncomment
+	if store != nil {
+		// the service-label store reads the labels of the services from the cluster state
+		if err := cluster.UpdateState(ctx); err != nil {
+			return err
+		}
+
+		if err := service.LoadScalingState(ctx, store); err != nil {
+			return err
+		}
 	}
+
+	go handleSignals(ctx, cancel, opts.configPath)
+
+	c.Run(ctx)
+
+	// decisions are left over when the controller stopped between the evaluate and act stages
+	logShutdownSummary(append(abandoned, decisions...))
+
+	return nil
 }
 
-func mainRecovering(configPath string) {
-	sigHUP := make(chan os.Signal, 1)
-	signal.Notify(sigHUP, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGKILL)
-
-	defer func() {
-		if err := recover(); err != nil {
-			var buf bytes.Buffer
-			s := err.(string)
-			buf.WriteString(s)
-			buf.WriteString("\n")
-			os.Stderr.WriteString(buf.String())
-		}
+// logShutdownSummary reports the scaling operations that were abandoned because the shutdown timeout expired
+func logShutdownSummary(abandoned []service.ScalingDecision) {
+	if len(abandoned) == 0 {
+		log.WithField("event", "shutdown").Info("autoscaler stopped without abandoning any scaling operation")
 
-		signal.Reset(syscall.SIGHUP, syscall.SIGTERM, syscall.SIGKILL)
-		close(sigHUP)
-	}()
+		return
+	}
 
-	schedule(func() { service.UpdateConfig(configPath) }, "config update")
-	schedule(func() { cluster.UpdateState() }, "cluster state")
-	schedule(func() { service.ScaleServices() }, "services scaling")
+	operations := make([]string, len(abandoned))
+	for i, d := range abandoned {
+		operations[i] = fmt.Sprintf("scale %s service %s from %d to %d instances through its %s",
+			d.Direction, d.ServiceConfig.Name, d.From, d.To, d.Strategy)
+	}
 
-	<-sigHUP
-	os.Exit(0)
+	log.WithFields(log.Fields{
+		"event":     "shutdown",
+		"abandoned": operations,
+	}).Warnf("autoscaler stopped abandoning %d scaling operations, the affected services may be partially scaled",
+		len(abandoned))
 }
 
-func schedule(f func(), desc string) {
-	log.Debugf("scheduling %s", desc)
+// handleSignals reloads the configuration on SIGHUP or when the configuration file changes and cancels the controller
+// on SIGTERM or SIGINT, exiting right away if one of them is received again while the controller drains
+func handleSignals(ctx context.Context, cancel context.CancelFunc, configPath string) {
+	sigHUP := make(chan os.Signal, 1)
+	signal.Notify(sigHUP, syscall.SIGHUP)
+
+	sigTERM := make(chan os.Signal, 1)
+	signal.Notify(sigTERM, syscall.SIGTERM, syscall.SIGINT)
 
-	f()
+	defer signal.Stop(sigHUP)
+	defer signal.Stop(sigTERM)
 
-	time.Sleep(time.Duration(5) * time.Second)
+	configChanged := config.Watch(configPath, configWatchInterval, ctx.Done())
 
-	go schedule(f, desc)
+	for {
+		select {
+		case <-sigHUP:
+			reloadConfig(configPath, "SIGHUP")
+		case <-configChanged:
+			reloadConfig(configPath, "file change")
+		case sig := <-sigTERM:
+			if ctx.Err() != nil {
+				log.Warnf("exiting immediately on second %s", sig)
+				os.Exit(1)
+			}
+
+			log.Infof("stopping on %s, send it again to exit immediately", sig)
+			cancel()
+		}
+	}
+}
+
+// reloadConfig swaps the active configuration, keeping the previous one if the file at configPath is invalid
+func reloadConfig(configPath string, trigger string) {
+	log.Infof("reloading configuration on %s", trigger)
+
+	if err := service.UpdateConfig(configPath); err != nil {
+		log.Errorf("keeping previous configuration: %s", err)
+	}
 }
@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"../types"
+)
+
+// Sample is the resource usage of a container at a point in time
+type Sample struct {
+	Timestamp time.Time
+	Usage     types.ContainerResourceUsage
+}
+
+// Window aggregates the usage samples of a container taken over a period of time
+type Window struct {
+	Samples int
+	Average types.ContainerResourceUsage
+	Max     types.ContainerResourceUsage
+	P50     types.ContainerResourceUsage
+	P90     types.ContainerResourceUsage
+	P95     types.ContainerResourceUsage
+	P99     types.ContainerResourceUsage
+}
+
+// DefaultRetention is how long samples are kept until SetRetention is called
+const DefaultRetention = 10 * time.Minute
+
+var (
+	lock      sync.Mutex
+	samples   = map[string][]Sample{}
+	retention = DefaultRetention
+)
+
+// SetRetention sets how long samples are kept, which must cover the longest period they are aggregated over
+//
+// The latest sample of a container is always kept regardless of its age
+func SetRetention(d time.Duration) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	retention = d
+}
+
+// Record adds a usage sample to the rolling window of a container and drops the samples older than the retention
+//
+// Samples must be recorded in chronological order, a sample that is not newer than the latest one of its container is
+// already in the window and is ignored
+func Record(containerID string, sample Sample) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if n := len(samples[containerID]); n > 0 && !sample.Timestamp.After(samples[containerID][n-1].Timestamp) {
+		return
+	}
+
+	containerSamples := append(samples[containerID], sample)
+
+	expired := 0
+	for expired < len(containerSamples)-1 && sample.Timestamp.Sub(containerSamples[expired].Timestamp) > retention {
+		expired++
+	}
+
+	samples[containerID] = containerSamples[expired:]
+}
+
+// Aggregate computes the statistics of the samples of a container taken in the period before now
+//
+// A zero period aggregates the latest sample only, and a container without samples yields an empty Window
+func Aggregate(containerID string, period time.Duration, now time.Time) Window {
+	lock.Lock()
+	containerSamples := samples[containerID]
+	lock.Unlock()
+
+	if len(containerSamples) == 0 {
+		return Window{}
+	}
+
+	if period <= 0 {
+		containerSamples = containerSamples[len(containerSamples)-1:]
+	} else {
+		start := sort.Search(len(containerSamples), func(i int) bool {
+			return now.Sub(containerSamples[i].Timestamp) <= period
+		})
+
+		containerSamples = containerSamples[start:]
+	}
+
+	if len(containerSamples) == 0 {
+		return Window{}
+	}
+
+	cpu := make([]float64, len(containerSamples))
+	memory := make([]float64, len(containerSamples))
+
+	for i, s := range containerSamples {
+		cpu[i] = s.Usage.CPU
+		memory[i] = s.Usage.Memory
+	}
+
+	sort.Float64s(cpu)
+	sort.Float64s(memory)
+
+	return Window{
+		Samples: len(containerSamples),
+		Average: types.ContainerResourceUsage{CPU: average(cpu), Memory: average(memory)},
+		Max:     types.ContainerResourceUsage{CPU: cpu[len(cpu)-1], Memory: memory[len(memory)-1]},
+		P50:     types.ContainerResourceUsage{CPU: percentile(cpu, 50), Memory: percentile(memory, 50)},
+		P90:     types.ContainerResourceUsage{CPU: percentile(cpu, 90), Memory: percentile(memory, 90)},
+		P95:     types.ContainerResourceUsage{CPU: percentile(cpu, 95), Memory: percentile(memory, 95)},
+		P99:     types.ContainerResourceUsage{CPU: percentile(cpu, 99), Memory: percentile(memory, 99)},
+	}
+}
+
+// Statistic returns the aggregated usage selected by one of the types.Statistic constants, defaulting to the average
+func (w Window) Statistic(statistic string) types.ContainerResourceUsage {
+	switch statistic {
+	case types.StatisticMax:
+		return w.Max
+	case types.StatisticP50:
+		return w.P50
+	case types.StatisticP90:
+		return w.P90
+	case types.StatisticP95:
+		return w.P95
+	case types.StatisticP99:
+		return w.P99
+	}
+
+	return w.Average
+}
+
+// Prune forgets the samples of every container that is not in containerIDs, e.g. because its task stopped
+func Prune(containerIDs map[string]bool) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for id := range samples {
+		if !containerIDs[id] {
+			delete(samples, id)
+		}
+	}
+}
+
+// Reset forgets all the samples
+func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
+
+	samples = map[string][]Sample{}
+}
+
+// average
+func average(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+
+	return sum / float64(len(values))
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100.0 * float64(len(sorted))))
+
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
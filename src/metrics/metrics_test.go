@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"../types"
+)
+
+func TestAggregate(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+
+	Reset()
+	SetRetention(time.Hour)
+
+	for i, cpu := range []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100} {
+		Record("container-1", Sample{
+			Timestamp: start.Add(time.Duration(i) * 10 * time.Second),
+			Usage:     types.ContainerResourceUsage{CPU: cpu, Memory: 100 - cpu},
+		})
+	}
+
+	now := start.Add(90 * time.Second)
+
+	tests := []struct {
+		name        string
+		containerID string
+		period      time.Duration
+		statistic   string
+		want        types.ContainerResourceUsage
+		wantSamples int
+	}{
+		{"average of all samples", "container-1", 90 * time.Second, types.StatisticAverage, types.ContainerResourceUsage{CPU: 55, Memory: 45}, 10},
+		{"default statistic", "container-1", 90 * time.Second, "", types.ContainerResourceUsage{CPU: 55, Memory: 45}, 10},
+		{"max", "container-1", 90 * time.Second, types.StatisticMax, types.ContainerResourceUsage{CPU: 100, Memory: 90}, 10},
+		{"median", "container-1", 90 * time.Second, types.StatisticP50, types.ContainerResourceUsage{CPU: 50, Memory: 40}, 10},
+		{"90th percentile", "container-1", 90 * time.Second, types.StatisticP90, types.ContainerResourceUsage{CPU: 90, Memory: 80}, 10},
+		{"99th percentile", "container-1", 90 * time.Second, types.StatisticP99, types.ContainerResourceUsage{CPU: 100, Memory: 90}, 10},
+		{"samples outside the period", "container-1", 20 * time.Second, types.StatisticAverage, types.ContainerResourceUsage{CPU: 90, Memory: 10}, 3},
+		{"zero period", "container-1", 0, types.StatisticMax, types.ContainerResourceUsage{CPU: 100}, 1},
+		{"unknown container", "container-2", time.Minute, types.StatisticAverage, types.ContainerResourceUsage{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window := Aggregate(tt.containerID, tt.period, now)
+
+			if window.Samples != tt.wantSamples {
+				t.Errorf("got %d samples, want %d", window.Samples, tt.wantSamples)
+			}
+
+			if got := window.Statistic(tt.statistic); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionAndPrune(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+
+	Reset()
+	SetRetention(time.Minute)
+
+	for i := 0; i < 10; i++ {
+		Record("container-1", Sample{Timestamp: start.Add(time.Duration(i) * 30 * time.Second)})
+	}
+
+	Record("container-2", Sample{Timestamp: start})
+
+	now := start.Add(270 * time.Second)
+
+	if window := Aggregate("container-1", time.Hour, now); window.Samples != 3 {
+		t.Errorf("got %d samples within the retention, want 3", window.Samples)
+	}
+
+	// the latest sample outlives the retention
+	if window := Aggregate("container-2", 0, now.Add(time.Hour)); window.Samples != 1 {
+		t.Errorf("got %d samples for an idle container, want 1", window.Samples)
+	}
+
+	Prune(map[string]bool{"container-1": true})
+
+	if window := Aggregate("container-2", 0, now); window.Samples != 0 {
+		t.Errorf("got %d samples for a pruned container, want 0", window.Samples)
+	}
+
+	if window := Aggregate("container-1", 0, now); window.Samples != 1 {
+		t.Errorf("got %d samples for a kept container, want 1", window.Samples)
+	}
+}
+
+func TestRecordIgnoresKnownSamples(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+
+	Reset()
+	SetRetention(time.Hour)
+
+	// the same collected sample is recorded again when nothing was collected in between
+	Record("container-1", Sample{Timestamp: start, Usage: types.ContainerResourceUsage{CPU: 90}})
+	Record("container-1", Sample{Timestamp: start, Usage: types.ContainerResourceUsage{CPU: 90}})
+	Record("container-1", Sample{Timestamp: start.Add(10 * time.Second), Usage: types.ContainerResourceUsage{CPU: 30}})
+	Record("container-1", Sample{Timestamp: start.Add(5 * time.Second), Usage: types.ContainerResourceUsage{CPU: 60}})
+
+	window := Aggregate("container-1", time.Minute, start.Add(10*time.Second))
+
+	if window.Samples != 2 {
+		t.Errorf("got %d samples, want 2", window.Samples)
+	}
+
+	if want := (types.ContainerResourceUsage{CPU: 60}); window.Average != want {
+		t.Errorf("got average %+v, want %+v", window.Average, want)
+	}
+}
@@ -0,0 +1,312 @@
+package scaler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"../cluster"
+	"../constraint"
+	"../types"
+)
+
+func init() {
+	Register(types.ScalingModeNodeLabel, newNodeLabelScaler)
+}
+
+// nodeLabelScaler scales a global mode service constrained on a node label by adding the label to or removing it from
+// nodes
+type nodeLabelScaler struct {
+	serviceConfig types.ServiceConfig
+	serviceState  types.ServiceState
+	clusterState  types.ClusterState
+	// constraints are the placement constraints of the service other than the one on its own label
+	constraints []constraint.Constraint
+}
+
+// newNodeLabelScaler
+func newNodeLabelScaler(serviceConfig types.ServiceConfig, serviceState types.ServiceState,
+	clusterState types.ClusterState) (Scaler, error) {
+	constraints, err := getOtherConstraints(serviceConfig, serviceState.Service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &nodeLabelScaler{
+		serviceConfig: serviceConfig,
+		serviceState:  serviceState,
+		clusterState:  clusterState,
+		constraints:   constraints,
+	}, nil
+}
+
+// GetPlacementNodes lists the running and active nodes whose load the scaler of a service weighs when placing or
+// stopping its instances, which are the nodes satisfying its placement constraints other than the one on its label
+// and running one of its platforms for the node_label scaling mode and none for the others
+func GetPlacementNodes(serviceConfig types.ServiceConfig, service types.Service,
+	clusterState types.ClusterState) ([]types.Node, error) {
+	if serviceConfig.ScalingMode != "" && serviceConfig.ScalingMode != types.ScalingModeNodeLabel {
+		return nil, nil
+	}
+
+	constraints, err := getOtherConstraints(serviceConfig, service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []types.Node{}
+
+	for _, n := range getRunningActiveNodes(clusterState) {
+		if matchesPlacement(constraints, service, n) {
+			nodes = append(nodes, n)
+		}
+	}
+
+	return nodes, nil
+}
+
+// getOtherConstraints parses the placement constraints of a service other than the one on its own label, which is
+// what the scaler satisfies by labeling nodes
+func getOtherConstraints(serviceConfig types.ServiceConfig, service types.Service) ([]constraint.Constraint, error) {
+	constraints, err := constraint.ParseAll(service.Constraints)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot evaluate the placement constraints of service %s: %s", serviceConfig.Name, err)
+	}
+
+	otherConstraints := []constraint.Constraint{}
+	for _, c := range constraints {
+		if c.Key != "node.labels."+serviceConfig.NodeLabel {
+			otherConstraints = append(otherConstraints, c)
+		}
+	}
+
+	return otherConstraints, nil
+}
+
+// matchesPlacement reports whether the docker scheduler may place a service on a node once it carries the service
+// label, which requires the node to satisfy the other placement constraints of the service and run one of its platforms
+func matchesPlacement(constraints []constraint.Constraint, service types.Service, node types.Node) bool {
+	return constraint.MatchAll(constraints, node) && constraint.MatchPlatforms(service.Platforms, node)
+}
+
+// CurrentCapacity returns the number of running and active nodes that carry the service label and satisfy the other
+// placement constraints and platforms of the service
+func (s *nodeLabelScaler) CurrentCapacity() int {
+	return len(s.getLabeledNodes())
+}
+
+// ScaleTo labels new nodes or unlabels the ones picked by the scale in strategy of the service until instances nodes
+// carry the service label
+func (s *nodeLabelScaler) ScaleTo(ctx context.Context, instances int) error {
+	capacity := s.CurrentCapacity()
+	instances = protectMinReplicas(s.serviceConfig, capacity, instances)
+
+	if instances > capacity {
+		newNodesNeeded := instances - capacity
+		newNodes := getNewNodesForService(s.serviceState, s.clusterState, s.getUnlabeledNodes(), newNodesNeeded)
+
+		if err := s.startServiceOnNodes(ctx, newNodes); err != nil {
+			return err
+		}
+
+		if len(newNodes) < newNodesNeeded {
+			return fmt.Errorf("needed to start %d new instances but only %d nodes are available",
+				newNodesNeeded, len(newNodes))
+		}
+	} else if instances < capacity {
+		nodesToStop := capacity - instances
+		nodes := getScaleInNodes(s.serviceConfig, s.serviceState, s.clusterState, s.getLabeledNodes(), nodesToStop,
+			nodeUsage)
+
+		if err := s.stopServiceOnNodes(ctx, nodes); err != nil {
+			return err
+		}
+
+		if len(nodes) < nodesToStop {
+			return fmt.Errorf("needed to stop %d instances but only %d of them have stats to pick them by",
+				nodesToStop, len(nodes))
+		}
+	}
+
+	return nil
+}
+
+// Describe
+func (s *nodeLabelScaler) Describe() string {
+	return fmt.Sprintf("node label %s", s.serviceConfig.NodeLabel)
+}
+
+// getLabeledNodes
+func (s *nodeLabelScaler) getLabeledNodes() []types.Node {
+	nodes := []types.Node{}
+
+	for _, n := range getRunningActiveNodes(s.clusterState) {
+		if _, ok := n.Labels[s.serviceConfig.NodeLabel]; ok && matchesPlacement(s.constraints, s.serviceState.Service, n) {
+			nodes = append(nodes, n)
+		}
+	}
+
+	return nodes
+}
+
+// getUnlabeledNodes lists the nodes where labeling would start the service, those not carrying the service label
+// that satisfy its other placement constraints and run one of its platforms
+func (s *nodeLabelScaler) getUnlabeledNodes() []types.Node {
+	nodes := []types.Node{}
+
+	for _, n := range getRunningActiveNodes(s.clusterState) {
+		if _, ok := n.Labels[s.serviceConfig.NodeLabel]; ok {
+			continue
+		}
+
+		if !matchesPlacement(s.constraints, s.serviceState.Service, n) {
+			log.WithFields(log.Fields{
+				"service": s.serviceConfig.Name,
+				"node":    n.Hostname,
+				"os":      n.Platform.OS,
+				"arch":    n.Platform.Architecture,
+			}).Debug("node does not satisfy the placement constraints or platforms of the service")
+
+			continue
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
+// startServiceOnNodes labels nodes so that the service starts on them, failing if any of them cannot be labeled
+func (s *nodeLabelScaler) startServiceOnNodes(ctx context.Context, nodes []string) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	log.Infof("starting service %s on %d nodes with label %s", s.serviceConfig.Name, len(nodes), s.serviceConfig.NodeLabel)
+
+	failed := []string{}
+
+	for i, n := range nodes {
+		if ctx.Err() != nil {
+			return fmt.Errorf("abandoned labeling nodes %v: %s", nodes[i:], ctx.Err())
+		}
+
+		if err := cluster.AddLabelToNode(ctx, n, s.serviceConfig.NodeLabel, "1"); err != nil {
+			// the node is abandoned too when its update was interrupted
+			if ctx.Err() != nil {
+				return fmt.Errorf("abandoned labeling nodes %v: %s", nodes[i:], ctx.Err())
+			}
+
+			log.Errorf("cannot start service %s on node %s: %s", s.serviceConfig.Name, n, err)
+			failed = append(failed, fmt.Sprintf("%s (%s)", n, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed labeling %d out of %d nodes: %s", len(failed), len(nodes), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// stopServiceOnNodes removes the service label from nodes, failing if it cannot be removed from any of them
+func (s *nodeLabelScaler) stopServiceOnNodes(ctx context.Context, nodes []string) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	log.Infof("stopping service %s on %d nodes with label %s", s.serviceConfig.Name, len(nodes), s.serviceConfig.NodeLabel)
+
+	failed := []string{}
+
+	for i, n := range nodes {
+		if ctx.Err() != nil {
+			return fmt.Errorf("abandoned unlabeling nodes %v: %s", nodes[i:], ctx.Err())
+		}
+
+		if err := cluster.RemoveLabelFromNode(ctx, n, s.serviceConfig.NodeLabel); err != nil {
+			// the node is abandoned too when its update was interrupted
+			if ctx.Err() != nil {
+				return fmt.Errorf("abandoned unlabeling nodes %v: %s", nodes[i:], ctx.Err())
+			}
+
+			log.Errorf("cannot stop service %s on node %s: %s", s.serviceConfig.Name, n, err)
+			failed = append(failed, fmt.Sprintf("%s (%s)", n, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed unlabeling %d out of %d nodes: %s", len(failed), len(nodes), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// getRunningActiveNodes lists the nodes of a cluster state snapshot ordered by their ID
+func getRunningActiveNodes(clusterState types.ClusterState) []types.Node {
+	nodes := make([]types.Node, 0, len(clusterState.RunningActiveNodes))
+	for _, n := range clusterState.RunningActiveNodes {
+		nodes = append(nodes, n)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	return nodes
+}
+
+// getNewNodesForService picks the count least loaded nodes that do not run the service yet and can fit its
+// reservations, spread over the groups of nodes of the spread preferences of the service if it has any
+func getNewNodesForService(serviceState types.ServiceState, clusterState types.ClusterState, allNodes []types.Node,
+	count int) (nodes []string) {
+	nodes = []string{}
+
+	serviceNodesMap := map[string]bool{}
+
+	for _, r := range serviceState.RunningServiceInstances {
+		serviceNodesMap[r.Node.ID] = true
+	}
+
+	candidates := []types.Node{}
+
+	for _, n := range allNodes {
+		if _, isServiceOnNode := serviceNodesMap[n.ID]; !isServiceOnNode {
+			candidates = append(candidates, n)
+		}
+	}
+
+	// every running instance counts for the spread, even the ones without stats yet
+	serviceNodes := []types.Node{}
+	for _, t := range clusterState.RunningTasks {
+		if n, ok := clusterState.RunningActiveNodes[t.NodeID]; ok && t.ServiceID == serviceState.Service.ID {
+			serviceNodes = append(serviceNodes, n)
+		}
+	}
+
+	scores := scoreNodes(serviceState.Service, candidates, clusterState, nodeUsage)
+
+	for _, s := range spreadNodes(serviceState.Service.Preferences, serviceNodes, scores) {
+		if len(nodes) == count {
+			break
+		}
+
+		log.WithFields(log.Fields{
+			"service": serviceState.Service.Name,
+			"node":    s.Node.Hostname,
+			"cpu":     s.CPU,
+			"memory":  s.Memory,
+			"score":   s.Score,
+		}).Infof("placing service %s on node %s with score %.1f", serviceState.Service.Name, s.Node.Hostname, s.Score)
+
+		nodes = append(nodes, s.Node.ID)
+	}
+
+	return nodes
+}
@@ -0,0 +1,187 @@
+package scaler
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"../cluster"
+	"../types"
+)
+
+// nodeScore is how suitable a node is to run one more instance of a service
+type nodeScore struct {
+	Node types.Node
+	// CPU and Memory are the loads of the node in percent, the larger of its reservations and its utilisation
+	CPU    float64
+	Memory float64
+	// Score is the percentage of the most loaded resource of the node that is still free, the higher the better
+	Score float64
+}
+
+// scoreNodes scores the nodes that can fit the reservations of the service, from the most to the least suitable
+//
+// The load of a resource counts what the tasks running on the node reserved even when they do not use it, as the
+// docker scheduler does, and what they use beyond their reservations
+func scoreNodes(service types.Service, nodes []types.Node, clusterState types.ClusterState,
+	nodeUsage func(types.Node) types.NodeUsage) []nodeScore {
+	reserved := getReservedResources(clusterState)
+	scores := []nodeScore{}
+
+	for _, n := range nodes {
+		r := reserved[n.ID]
+
+		if !fits(service.Reservations.NanoCPUs, r.NanoCPUs, n.Resources.NanoCPUs) ||
+			!fits(service.Reservations.MemoryBytes, r.MemoryBytes, n.Resources.MemoryBytes) {
+			log.WithFields(log.Fields{
+				"service":         service.Name,
+				"node":            n.Hostname,
+				"reserved_cpus":   float64(r.NanoCPUs) / 1e9,
+				"reserved_memory": r.MemoryBytes,
+			}).Debug("node cannot fit the reservations of the service")
+
+			continue
+		}
+
+		usage := nodeUsage(n)
+
+		s := nodeScore{
+			Node:   n,
+			CPU:    math.Max(percentage(r.NanoCPUs, n.Resources.NanoCPUs), usage.CPU),
+			Memory: math.Max(percentage(r.MemoryBytes, n.Resources.MemoryBytes), usage.Memory),
+		}
+		s.Score = math.Max(0, 100.0-math.Max(s.CPU, s.Memory))
+
+		log.WithFields(log.Fields{
+			"service":         service.Name,
+			"node":            n.Hostname,
+			"cpu":             s.CPU,
+			"memory":          s.Memory,
+			"score":           s.Score,
+			"estimated_usage": usage.Estimated,
+		}).Debug("scored node for placement")
+
+		scores = append(scores, s)
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+
+	return scores
+}
+
+// spreadNodes reorders scored nodes so that taking them in order spreads the instances of a service evenly over the
+// groups of nodes its spread preferences divide the nodes into, counting the instances it runs on serviceNodes
+//
+// Like the docker scheduler, the first preference splits the nodes into groups by the value of its label, the next
+// one splits each of these groups further, and so on, the nodes missing a label forming a group of their own. A node
+// is taken from the group with the fewest instances at the first level they differ, the score breaking the ties
+func spreadNodes(preferences []string, serviceNodes []types.Node, scores []nodeScore) []nodeScore {
+	if len(preferences) == 0 {
+		return scores
+	}
+
+	counts := map[string]int{}
+	for _, n := range serviceNodes {
+		for _, g := range getSpreadGroups(preferences, n) {
+			counts[g]++
+		}
+	}
+
+	remaining := append([]nodeScore{}, scores...)
+	result := make([]nodeScore, 0, len(scores))
+
+	for len(remaining) > 0 {
+		best := 0
+
+		for i := 1; i < len(remaining); i++ {
+			if hasFewerInstances(counts, getSpreadGroups(preferences, remaining[i].Node),
+				getSpreadGroups(preferences, remaining[best].Node)) {
+				best = i
+			}
+		}
+
+		for _, g := range getSpreadGroups(preferences, remaining[best].Node) {
+			counts[g]++
+		}
+
+		result = append(result, remaining[best])
+		remaining = append(remaining[:best], remaining[best+1:]...)
+	}
+
+	return result
+}
+
+// getSpreadGroups returns the group of a node at every level of the spread preferences, from the first one
+func getSpreadGroups(preferences []string, node types.Node) []string {
+	groups := make([]string, len(preferences))
+	path := ""
+
+	for i, descriptor := range preferences {
+		path += "\x00" + getSpreadValue(descriptor, node)
+		groups[i] = path
+	}
+
+	return groups
+}
+
+// getSpreadValue returns the value of the node or engine label a spread preference is on, empty if the node does not
+// have it
+func getSpreadValue(descriptor string, node types.Node) string {
+	switch key := strings.ToLower(descriptor); {
+	case strings.HasPrefix(key, "node.labels."):
+		return node.Labels[descriptor[len("node.labels."):]]
+	case strings.HasPrefix(key, "engine.labels."):
+		return node.EngineLabels[descriptor[len("engine.labels."):]]
+	}
+
+	return ""
+}
+
+// hasFewerInstances reports whether the groups of a node hold fewer instances than the other groups at the first level
+// where their counts differ
+func hasFewerInstances(counts map[string]int, groups []string, otherGroups []string) bool {
+	for i := range groups {
+		if counts[groups[i]] != counts[otherGroups[i]] {
+			return counts[groups[i]] < counts[otherGroups[i]]
+		}
+	}
+
+	return false
+}
+
+// getReservedResources sums the reservations of the running tasks by node ID
+func getReservedResources(clusterState types.ClusterState) map[string]types.NodeResources {
+	reserved := map[string]types.NodeResources{}
+
+	for _, t := range clusterState.RunningTasks {
+		r := reserved[t.NodeID]
+		r.NanoCPUs += t.Reservations.NanoCPUs
+		r.MemoryBytes += t.Reservations.MemoryBytes
+		reserved[t.NodeID] = r
+	}
+
+	return reserved
+}
+
+// fits reports whether a reservation fits in what is left of a capacity, a zero capacity being unknown
+func fits(reservation int64, reserved int64, capacity int64) bool {
+	return reservation == 0 || capacity == 0 || reserved+reservation <= capacity
+}
+
+// percentage
+func percentage(value int64, total int64) float64 {
+	if total == 0 {
+		return 0.0
+	}
+
+	return float64(value) / float64(total) * 100.0
+}
+
+// nodeUsage returns the usage of a node known to the cluster package
+func nodeUsage(node types.Node) types.NodeUsage {
+	return cluster.GetNodeUsage(node)
+}
@@ -0,0 +1,205 @@
+package scaler
+
+import (
+	"reflect"
+	"testing"
+
+	"../types"
+)
+
+func TestScoreNodes(t *testing.T) {
+	node := func(id string) types.Node {
+		return types.Node{ID: id, Hostname: id, Resources: types.NodeResources{NanoCPUs: 2e9, MemoryBytes: 4 << 30}}
+	}
+
+	clusterState := types.NewClusterState()
+	clusterState.RunningTasks["task-1"] = types.RunningTask{
+		ID:           "task-1",
+		NodeID:       "node-2",
+		Reservations: types.NodeResources{NanoCPUs: 1e9},
+	}
+	clusterState.RunningTasks["task-2"] = types.RunningTask{
+		ID:           "task-2",
+		NodeID:       "node-3",
+		Reservations: types.NodeResources{MemoryBytes: 3 << 30},
+	}
+
+	usages := map[string]types.NodeUsage{
+		"node-1": {CPU: 70, Memory: 20},
+		"node-2": {CPU: 10, Memory: 10},
+		"node-4": {CPU: 20, Memory: 30},
+	}
+
+	nodeUsage := func(n types.Node) types.NodeUsage {
+		return usages[n.ID]
+	}
+
+	nodes := []types.Node{node("node-1"), node("node-2"), node("node-3"), node("node-4")}
+
+	tests := []struct {
+		name         string
+		reservations types.NodeResources
+		want         []nodeScore
+	}{
+		{
+			name: "no reservations",
+			want: []nodeScore{
+				{Node: node("node-4"), CPU: 20, Memory: 30, Score: 70},
+				{Node: node("node-2"), CPU: 50, Memory: 10, Score: 50},
+				{Node: node("node-1"), CPU: 70, Memory: 20, Score: 30},
+				{Node: node("node-3"), CPU: 0, Memory: 75, Score: 25},
+			},
+		},
+		{
+			name:         "reservations not fitting every node",
+			reservations: types.NodeResources{NanoCPUs: 15e8, MemoryBytes: 3 << 29},
+			want: []nodeScore{
+				{Node: node("node-4"), CPU: 20, Memory: 30, Score: 70},
+				{Node: node("node-1"), CPU: 70, Memory: 20, Score: 30},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := types.Service{Name: "api", Reservations: tt.reservations}
+
+			if got := scoreNodes(service, nodes, clusterState, nodeUsage); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scoreNodes() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpreadNodes(t *testing.T) {
+	node := func(id string, labels map[string]string) types.Node {
+		return types.Node{ID: id, Hostname: id, Labels: labels}
+	}
+
+	a1 := node("a1", map[string]string{"zone": "a", "rack": "1"})
+	a1Other := node("a1-other", map[string]string{"zone": "a", "rack": "1"})
+	a2 := node("a2", map[string]string{"zone": "a", "rack": "2"})
+	b1 := node("b1", map[string]string{"zone": "b", "rack": "1"})
+	unlabeled := node("unlabeled", nil)
+
+	tests := []struct {
+		name         string
+		preferences  []string
+		serviceNodes []types.Node
+		candidates   []types.Node
+		want         []string
+	}{
+		{
+			name:       "no preferences",
+			candidates: []types.Node{a1, a2, b1, unlabeled},
+			want:       []string{"a1", "a2", "b1", "unlabeled"},
+		},
+		{
+			name:        "spread over zones",
+			preferences: []string{"node.labels.zone"},
+			candidates:  []types.Node{a1, a2, b1, unlabeled},
+			want:        []string{"a1", "b1", "unlabeled", "a2"},
+		},
+		{
+			name:         "spread over zones already running instances",
+			preferences:  []string{"node.labels.zone"},
+			serviceNodes: []types.Node{a1Other, a1Other},
+			candidates:   []types.Node{a1, a2, b1, unlabeled},
+			want:         []string{"b1", "unlabeled", "a1", "a2"},
+		},
+		{
+			name:         "spread over racks within zones",
+			preferences:  []string{"node.labels.zone", "node.labels.rack"},
+			serviceNodes: []types.Node{a1, b1},
+			candidates:   []types.Node{a1Other, a2},
+			want:         []string{"a2", "a1-other"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the candidates are given from the highest to the lowest score
+			scores := make([]nodeScore, len(tt.candidates))
+			for i, n := range tt.candidates {
+				scores[i] = nodeScore{Node: n, Score: float64(100 - i)}
+			}
+
+			got := []string{}
+			for _, s := range spreadNodes(tt.preferences, tt.serviceNodes, scores) {
+				got = append(got, s.Node.ID)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("spreadNodes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPlacementNodes(t *testing.T) {
+	clusterState := types.NewClusterState()
+	for _, n := range []types.Node{
+		{ID: "amd64", Role: "worker", Platform: types.Platform{OS: "linux", Architecture: "x86_64"}},
+		{ID: "arm64", Role: "worker", Platform: types.Platform{OS: "linux", Architecture: "aarch64"}},
+		{ID: "windows", Role: "worker", Platform: types.Platform{OS: "windows", Architecture: "x86_64"}},
+		{ID: "manager", Role: "manager", Platform: types.Platform{OS: "linux", Architecture: "x86_64"}},
+	} {
+		clusterState.RunningActiveNodes[n.ID] = n
+	}
+
+	serviceConfig := types.ServiceConfig{Name: "portainer", NodeLabel: "portainer"}
+
+	tests := []struct {
+		name        string
+		scalingMode string
+		platforms   []types.Platform
+		want        []string
+	}{
+		{name: "any platform", want: []string{"amd64", "arm64", "windows"}},
+		{
+			name:      "architecture mismatch",
+			platforms: []types.Platform{{OS: "linux", Architecture: "amd64"}},
+			want:      []string{"amd64"},
+		},
+		{
+			name:      "operating system mismatch",
+			platforms: []types.Platform{{OS: "linux"}},
+			want:      []string{"amd64", "arm64"},
+		},
+		{
+			name:      "no matching platform",
+			platforms: []types.Platform{{OS: "linux", Architecture: "s390x"}},
+			want:      []string{},
+		},
+		{name: "replicas scaling mode", scalingMode: types.ScalingModeReplicas},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serviceConfig.ScalingMode = tt.scalingMode
+			service := types.Service{
+				Name:        "portainer",
+				Constraints: []string{"node.labels.portainer == 1", "node.role == worker"},
+				Platforms:   tt.platforms,
+			}
+
+			nodes, err := GetPlacementNodes(serviceConfig, service, clusterState)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			if nodes != nil {
+				got = []string{}
+				for _, n := range nodes {
+					got = append(got, n.ID)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetPlacementNodes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package scaler
+
+import (
+	"context"
+	"fmt"
+
+	"../cluster"
+	"../types"
+)
+
+func init() {
+	Register(types.ScalingModeReplicas, newReplicasScaler)
+}
+
+// replicasScaler scales a replicated mode service by updating its replica count
+type replicasScaler struct {
+	serviceConfig types.ServiceConfig
+	service       types.Service
+}
+
+// newReplicasScaler
+func newReplicasScaler(serviceConfig types.ServiceConfig, serviceState types.ServiceState,
+	clusterState types.ClusterState) (Scaler, error) {
+	if serviceState.Service.ID == "" {
+		return nil, fmt.Errorf("service %s does not exist", serviceConfig.Name)
+	}
+
+	if serviceState.Service.Mode != types.ServiceModeReplicated {
+		return nil, fmt.Errorf("service %s is in %s mode and cannot be scaled by replicas",
+			serviceConfig.Name, serviceState.Service.Mode)
+	}
+
+	return &replicasScaler{serviceConfig: serviceConfig, service: serviceState.Service}, nil
+}
+
+// CurrentCapacity returns the replica count of the service, which includes the tasks that are still pending
+func (s *replicasScaler) CurrentCapacity() int {
+	return int(s.service.Replicas)
+}
+
+// ScaleTo updates the replica count of the service
+func (s *replicasScaler) ScaleTo(ctx context.Context, instances int) error {
+	instances = protectMinReplicas(s.serviceConfig, s.CurrentCapacity(), instances)
+
+	return cluster.SetServiceReplicas(ctx, s.service.ID, uint64(instances))
+}
+
+// Describe
+func (s *replicasScaler) Describe() string {
+	return fmt.Sprintf("replica count of service %s", s.service.Name)
+}
@@ -0,0 +1,159 @@
+package scaler
+
+import (
+	"math"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"../types"
+	"../utils"
+)
+
+// protectMinReplicas raises the number of instances a service is scaled in to back to its min replicas, so that no
+// scale in ever stops the instances it must keep
+func protectMinReplicas(serviceConfig types.ServiceConfig, capacity int, instances int) int {
+	if instances >= capacity || instances >= serviceConfig.MinReplicas {
+		return instances
+	}
+
+	protected := serviceConfig.MinReplicas
+	if protected > capacity {
+		protected = capacity
+	}
+
+	log.Warnf("not scaling service %s in to %d instances, below its %d min replicas, keeping %d",
+		serviceConfig.Name, instances, serviceConfig.MinReplicas, protected)
+
+	return protected
+}
+
+// getScaleInNodes picks up to count nodes to stop the instances of a service on, first the given nodes that run no
+// task of the service, then the nodes of its instances in the order of its scale in strategy
+//
+// Only the given nodes are candidates, as removing the label from any other node would not reduce the capacity of the
+// service. The nodes running a task without collected stats cannot be ranked and are never picked, nor by the least
+// loaded strategy the nodes whose instance has no samples over the scale in period, so fewer than count nodes are
+// returned when there are not enough candidates
+func getScaleInNodes(serviceConfig types.ServiceConfig, serviceState types.ServiceState,
+	clusterState types.ClusterState, nodes []types.Node, count int,
+	nodeUsage func(types.Node) types.NodeUsage) []string {
+	running := map[string]bool{}
+	for _, t := range clusterState.RunningTasks {
+		if t.ServiceID == serviceState.Service.ID {
+			running[t.NodeID] = true
+		}
+	}
+
+	result := []string{}
+	eligible := map[string]bool{}
+
+	for _, n := range nodes {
+		if !running[n.ID] {
+			if len(result) < count {
+				result = append(result, n.ID)
+			}
+
+			continue
+		}
+
+		eligible[n.ID] = true
+	}
+
+	candidates := []types.RunningServiceInstance{}
+	for _, r := range serviceState.RunningServiceInstances {
+		if eligible[r.Node.ID] {
+			candidates = append(candidates, r)
+		}
+	}
+
+	// the instances come from a map, sort them so that ties are always broken the same way
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Node.ID < candidates[j].Node.ID
+	})
+
+	var less func(r1 types.RunningServiceInstance, r2 types.RunningServiceInstance) bool
+
+	switch serviceConfig.ScaleInStrategy {
+	case types.ScaleInStrategyNewest:
+		less = func(r1 types.RunningServiceInstance, r2 types.RunningServiceInstance) bool {
+			return r1.Task.CreatedAt.After(r2.Task.CreatedAt)
+		}
+	case types.ScaleInStrategyOldest:
+		less = func(r1 types.RunningServiceInstance, r2 types.RunningServiceInstance) bool {
+			return r1.Task.CreatedAt.Before(r2.Task.CreatedAt)
+		}
+	case types.ScaleInStrategyMostOtherLoad:
+		reserved := getReservedResources(clusterState)
+		loads := map[string]float64{}
+
+		for _, r := range candidates {
+			loads[r.Node.ID] = getOtherLoad(r, reserved[r.Node.ID], nodeUsage(r.Node))
+
+			log.WithFields(log.Fields{
+				"service":    serviceConfig.Name,
+				"node":       r.Node.Hostname,
+				"other_load": loads[r.Node.ID],
+			}).Debug("computed the load of the node besides the service")
+		}
+
+		less = func(r1 types.RunningServiceInstance, r2 types.RunningServiceInstance) bool {
+			return loads[r1.Node.ID] > loads[r2.Node.ID]
+		}
+	default:
+		// an instance without samples over the scale in period reports no usage rather than a low one
+		sampled := []types.RunningServiceInstance{}
+		for _, r := range candidates {
+			if r.ScaleInSamples > 0 {
+				sampled = append(sampled, r)
+			}
+		}
+
+		candidates = sampled
+
+		less = func(r1 types.RunningServiceInstance, r2 types.RunningServiceInstance) bool {
+			if r1.ScaleInUsage.CPU == r2.ScaleInUsage.CPU {
+				return r1.ScaleInUsage.Memory < r2.ScaleInUsage.Memory
+			}
+
+			return r1.ScaleInUsage.CPU < r2.ScaleInUsage.CPU
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return less(candidates[i], candidates[j])
+	})
+
+	for _, r := range candidates {
+		if len(result) == count {
+			break
+		}
+
+		result = append(result, r.Node.ID)
+	}
+
+	return result
+}
+
+// getOtherLoad returns the load, in percent of its most loaded resource, that the node of an instance keeps once the
+// instance is stopped: the larger of what the other tasks reserved on it and what they use
+func getOtherLoad(instance types.RunningServiceInstance, reserved types.NodeResources, usage types.NodeUsage) float64 {
+	node := instance.Node
+	stats := instance.ContainerStats
+
+	// the usage of the instance as a share of its node, the way the cluster package estimates the node usage
+	instanceCPU := 0.0
+	if node.Resources.NanoCPUs > 0 {
+		instanceCPU = utils.ExtractContainerResourceUsage(stats.Raw, 0).CPU / (float64(node.Resources.NanoCPUs) / 1e9)
+	}
+
+	instanceMemory := percentage(int64(stats.Usage.Memory/100.0*float64(stats.Raw.MemoryStats.Limit)),
+		node.Resources.MemoryBytes)
+
+	cpu := math.Max(percentage(reserved.NanoCPUs-instance.Task.Reservations.NanoCPUs, node.Resources.NanoCPUs),
+		usage.CPU-instanceCPU)
+	memory := math.Max(percentage(reserved.MemoryBytes-instance.Task.Reservations.MemoryBytes,
+		node.Resources.MemoryBytes), usage.Memory-instanceMemory)
+
+	return math.Max(0, math.Max(cpu, memory))
+}
@@ -0,0 +1,173 @@
+package scaler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"../types"
+)
+
+func TestGetScaleInNodes(t *testing.T) {
+	node := func(id string) types.Node {
+		return types.Node{ID: id, Hostname: id, Resources: types.NodeResources{NanoCPUs: 2e9, MemoryBytes: 4 << 30}}
+	}
+
+	created := time.Unix(1500000000, 0)
+	instance := func(nodeID string, cpu float64, age time.Duration) types.RunningServiceInstance {
+		return types.RunningServiceInstance{
+			Node: node(nodeID),
+			Task: types.RunningTask{
+				ID:        "task-" + nodeID,
+				ServiceID: "service-1",
+				NodeID:    nodeID,
+				CreatedAt: created.Add(-age),
+			},
+			ScaleInUsage:   types.ContainerResourceUsage{CPU: cpu, Memory: 10},
+			ScaleInSamples: 6,
+		}
+	}
+
+	serviceState := types.ServiceState{
+		Service: types.Service{ID: "service-1", Name: "portainer"},
+		RunningServiceInstances: []types.RunningServiceInstance{
+			instance("node-3", 5, time.Hour),
+			instance("node-1", 20, 3*time.Hour),
+			instance("node-4", 5, 2*time.Hour),
+			instance("node-2", 1, 4*time.Hour),
+			instance("node-5", 0, 5*time.Hour),
+		},
+	}
+
+	clusterState := types.NewClusterState()
+	for _, r := range serviceState.RunningServiceInstances {
+		clusterState.RunningTasks[r.Task.ID] = r.Task
+	}
+
+	clusterState.RunningTasks["other-1"] = types.RunningTask{
+		ID:           "other-1",
+		NodeID:       "node-1",
+		Reservations: types.NodeResources{NanoCPUs: 1e9},
+	}
+
+	usages := map[string]types.NodeUsage{
+		"node-3": {CPU: 80, Memory: 20},
+		"node-4": {CPU: 10, Memory: 60},
+	}
+
+	nodeUsage := func(n types.Node) types.NodeUsage {
+		return usages[n.ID]
+	}
+
+	// node-5 runs an instance but does not count in the capacity of the service
+	nodes := []types.Node{node("node-1"), node("node-2"), node("node-3"), node("node-4")}
+
+	tests := []struct {
+		strategy string
+		count    int
+		want     []string
+	}{
+		{strategy: "", count: 2, want: []string{"node-2", "node-3"}},
+		{strategy: types.ScaleInStrategyLeastLoaded, count: 3, want: []string{"node-2", "node-3", "node-4"}},
+		{strategy: types.ScaleInStrategyNewest, count: 2, want: []string{"node-3", "node-4"}},
+		{strategy: types.ScaleInStrategyOldest, count: 2, want: []string{"node-2", "node-1"}},
+		{strategy: types.ScaleInStrategyMostOtherLoad, count: 3, want: []string{"node-3", "node-4", "node-1"}},
+		{strategy: types.ScaleInStrategyNewest, count: 10, want: []string{"node-3", "node-4", "node-1", "node-2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			serviceConfig := types.ServiceConfig{Name: "portainer", ScaleInStrategy: tt.strategy}
+			got := getScaleInNodes(serviceConfig, serviceState, clusterState, nodes, tt.count, nodeUsage)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got nodes %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetScaleInNodesWithoutStats(t *testing.T) {
+	node := func(id string) types.Node {
+		return types.Node{ID: id, Hostname: id}
+	}
+
+	instance := func(nodeID string, cpu float64, samples int) types.RunningServiceInstance {
+		return types.RunningServiceInstance{
+			Node:           node(nodeID),
+			Task:           types.RunningTask{ID: "task-" + nodeID, ServiceID: "service-1", NodeID: nodeID},
+			ScaleInUsage:   types.ContainerResourceUsage{CPU: cpu},
+			ScaleInSamples: samples,
+		}
+	}
+
+	// node-1 and node-3 run an instance with samples, node-6 an instance whose stats have no sample over the scale in
+	// period yet, node-2 runs a task whose stats were not collected and node-4 and node-5 carry the label without
+	// running any task of the service
+	serviceState := types.ServiceState{
+		Service: types.Service{ID: "service-1", Name: "portainer"},
+		RunningServiceInstances: []types.RunningServiceInstance{
+			instance("node-3", 10, 6),
+			instance("node-1", 20, 6),
+			instance("node-6", 0, 0),
+		},
+	}
+
+	clusterState := types.NewClusterState()
+	for _, id := range []string{"node-1", "node-2", "node-3", "node-6"} {
+		clusterState.RunningTasks["task-"+id] = types.RunningTask{ID: "task-" + id, ServiceID: "service-1", NodeID: id}
+	}
+
+	clusterState.RunningTasks["other-1"] = types.RunningTask{ID: "other-1", ServiceID: "service-2", NodeID: "node-4"}
+
+	nodes := []types.Node{node("node-1"), node("node-2"), node("node-3"), node("node-4"), node("node-5"), node("node-6")}
+
+	nodeUsage := func(n types.Node) types.NodeUsage {
+		return types.NodeUsage{}
+	}
+
+	tests := []struct {
+		count int
+		want  []string
+	}{
+		{count: 1, want: []string{"node-4"}},
+		{count: 2, want: []string{"node-4", "node-5"}},
+		{count: 3, want: []string{"node-4", "node-5", "node-3"}},
+		{count: 5, want: []string{"node-4", "node-5", "node-3", "node-1"}},
+	}
+
+	for _, tt := range tests {
+		serviceConfig := types.ServiceConfig{Name: "portainer"}
+		got := getScaleInNodes(serviceConfig, serviceState, clusterState, nodes, tt.count, nodeUsage)
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("count %d: got nodes %v, want %v", tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestProtectMinReplicas(t *testing.T) {
+	tests := []struct {
+		name        string
+		minReplicas int
+		capacity    int
+		instances   int
+		want        int
+	}{
+		{name: "scale in above min replicas", minReplicas: 2, capacity: 5, instances: 3, want: 3},
+		{name: "scale in to min replicas", minReplicas: 2, capacity: 5, instances: 2, want: 2},
+		{name: "scale in below min replicas", minReplicas: 2, capacity: 5, instances: 1, want: 2},
+		{name: "scale in below min replicas from below them", minReplicas: 4, capacity: 3, instances: 1, want: 3},
+		{name: "scale out", minReplicas: 2, capacity: 1, instances: 4, want: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serviceConfig := types.ServiceConfig{Name: "portainer", MinReplicas: tt.minReplicas}
+
+			if got := protectMinReplicas(serviceConfig, tt.capacity, tt.instances); got != tt.want {
+				t.Errorf("got %d instances, want %d", got, tt.want)
+			}
+		})
+	}
+}
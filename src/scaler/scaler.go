@@ -0,0 +1,47 @@
+package scaler
+
+import (
+	"context"
+	"fmt"
+
+	"../types"
+)
+
+// Scaler changes the number of instances of a single service using a particular scaling strategy
+type Scaler interface {
+	// CurrentCapacity returns the number of instances the service is currently meant to run, including the ones that
+	// are still starting
+	CurrentCapacity() int
+	// ScaleTo makes the service run the given number of instances
+	ScaleTo(ctx context.Context, instances int) error
+	// Describe returns a short human readable description of the scaling strategy and its target
+	Describe() string
+}
+
+// Factory creates the Scaler of a service from its configuration and its state in a cluster state snapshot
+type Factory func(serviceConfig types.ServiceConfig, serviceState types.ServiceState,
+	clusterState types.ClusterState) (Scaler, error)
+
+var factories = map[string]Factory{}
+
+// Register makes a scaling strategy available under name, the value of scaling_mode that selects it in the configuration
+func Register(name string, factory Factory) {
+	factories[name] = factory
+}
+
+// New creates the Scaler selected by the scaling_mode of a service, defaulting to ScalingModeNodeLabel
+func New(serviceConfig types.ServiceConfig, serviceState types.ServiceState,
+	clusterState types.ClusterState) (Scaler, error) {
+	mode := serviceConfig.ScalingMode
+	if mode == "" {
+		mode = types.ScalingModeNodeLabel
+	}
+
+	factory, ok := factories[mode]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown scaling mode %q", mode)
+	}
+
+	return factory(serviceConfig, serviceState, clusterState)
+}
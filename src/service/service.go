@@ -1,328 +1,692 @@
-package service
-
-import (
-	"math"
-	"sort"
-	"sync"
-	"time"
-
-	log "github.com/sirupsen/logrus"
-
-	"../cluster"
-	"../types"
-)
-
-var (
-	config              types.ServicesConfig
-	scaleOutStagingArea map[string]types.ServiceStagedScaling
-	scaleInStagingArea  map[string]types.ServiceStagedScaling
-)
-
-// ScaleServices scales the autoscaled services based on the provided configuration
-func ScaleServices() {
-	wg := sync.WaitGroup{}
-	for _, s := range config.Services {
-		wg.Add(1)
-		scaleService(s, &wg)
-	}
-	wg.Wait()
-}
-
-// UpdateConfig reads a json configuration file at configPath and caches the parsed ServicesConfig object
-func UpdateConfig(configPath string) {
-	config = types.ServicesConfig{
-		Services: []types.ServiceConfig{
-			types.ServiceConfig{
-				Name:        "portainer",
-				MinReplicas: 3,
-				MaxReplicas: 5,
-				ScaleOut: types.ServiceScaleConditions{
-					CPU:    20,
-					Memory: 50,
-					Period: "1m",
-				},
-				ScaleIn: types.ServiceScaleConditions{
-					CPU:    10,
-					Memory: 25,
-					Period: "1m",
-				},
-				NodeLabel: "portainer",
-			},
-		},
-	}
-}
-
-// scaleService
-func scaleService(serviceConfig types.ServiceConfig, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	serviceState := getServiceState(serviceConfig.Name)
-	runningServiceInstancesCount := len(serviceState.RunningServiceInstances)
-	serviceID := serviceState.Service.ID
-
-	if runningServiceInstancesCount < serviceConfig.MinReplicas {
-		newNodesNeeded := serviceConfig.MinReplicas - runningServiceInstancesCount
-		newNodes := getNewNodesForService(serviceState, cluster.GetRunningActiveNodes(), newNodesNeeded)
-
-		if len(newNodes) == 0 {
-			log.Warnf("Needed to start %d new instances for service %s but no nodes are available",
-				newNodesNeeded, serviceState.Service.Name)
-
-			return
-		}
-
-		startServiceOnNodes(serviceConfig, newNodes)
-
-		log.Infof("Started %d new instances for service %s", newNodesNeeded, serviceState.Service.Name)
-
-		delete(scaleOutStagingArea, serviceID)
-		delete(scaleInStagingArea, serviceID)
-
-		return
-	}
-
-	healthyServiceNodes, sickServiceNodes := categorizeNodesForService(serviceConfig, serviceState)
-	healthyServiceNodesCount, _ := len(healthyServiceNodes), len(sickServiceNodes)
-
-	if healthyServiceNodesCount < serviceConfig.MinReplicas {
-		if runningServiceInstancesCount == serviceConfig.MaxReplicas {
-			log.Warnf("Scaling needed to match minimum healthy for service %s but already using max replicas",
-				serviceState.Service.Name)
-
-			return
-		}
-
-		scaleOutPeriod, _ := time.ParseDuration(serviceConfig.ScaleOut.Period)
-		doScaleOut := false
-
-		if scaleOutPeriod.Seconds() != 0.0 {
-			if s, ok := scaleOutStagingArea[serviceID]; ok {
-				if float64(time.Now().Unix()-s.StagedTimestamp) >= scaleOutPeriod.Seconds() {
-					doScaleOut = true
-				}
-			} else {
-				scaleOutStagingArea[serviceID] = types.ServiceStagedScaling{
-					ServiceID:       serviceID,
-					StagedTimestamp: time.Now().Unix(),
-				}
-			}
-		} else {
-			doScaleOut = true
-		}
-
-		if doScaleOut {
-			newNodesNeededCount := serviceConfig.MinReplicas - healthyServiceNodesCount
-			newNodesAllowedCount := serviceConfig.MaxReplicas - runningServiceInstancesCount
-
-			if newNodesNeededCount > newNodesAllowedCount {
-				log.Warnf("Scaling by %d nodes needed for service %s but only %d more nodes can be used",
-					newNodesNeededCount,
-					newNodesAllowedCount,
-					serviceState.Service.Name)
-
-				newNodesNeededCount = newNodesAllowedCount
-			}
-
-			if newNodesNeededCount == 0 {
-				return
-			}
-
-			newNodes := getNewNodesForService(serviceState, cluster.GetRunningActiveNodes(), newNodesNeededCount)
-			startServiceOnNodes(serviceConfig, newNodes)
-
-			log.Infof("Started %d new instances for service %s because only %d instances are healthy", newNodesNeededCount, serviceState.Service.Name, healthyServiceNodesCount)
-
-			delete(scaleOutStagingArea, serviceID)
-			delete(scaleInStagingArea, serviceID)
-		}
-
-		return
-	}
-
-	if runningServiceInstancesCount == serviceConfig.MinReplicas {
-		log.Infof("No scaling needed for service %s", serviceState.Service.Name)
-
-		delete(scaleOutStagingArea, serviceID)
-		delete(scaleInStagingArea, serviceID)
-
-		return
-	}
-
-	// at this point we have more healthy instances than needed so we must scale in
-
-	scaleInPeriod, _ := time.ParseDuration(serviceConfig.ScaleIn.Period)
-	doScaleIn := false
-
-	if scaleInPeriod.Seconds() != 0.0 {
-		if s, ok := scaleInStagingArea[serviceID]; ok {
-			if float64(time.Now().Unix()-s.StagedTimestamp) >= scaleInPeriod.Seconds() {
-				doScaleIn = true
-			}
-		} else {
-			scaleInStagingArea[serviceID] = types.ServiceStagedScaling{
-				ServiceID:       serviceID,
-				StagedTimestamp: time.Now().Unix(),
-			}
-		}
-	} else {
-		doScaleIn = true
-	}
-
-	if doScaleIn {
-		extraNodesCount := healthyServiceNodesCount - serviceConfig.MinReplicas
-		nodes := getLeastLoadedNodes(serviceState, extraNodesCount)
-
-		stopServiceOnNodes(serviceConfig, nodes)
-
-		delete(scaleOutStagingArea, serviceID)
-		delete(scaleInStagingArea, serviceID)
-	}
-}
-
-// getServiceState
-func getServiceState(serviceName string) types.ServiceState {
-	var result types.ServiceState
-
-	clusterState := cluster.GetState()
-
-	var serviceID string
-	for _, s := range clusterState.Services {
-		if s.Name == serviceName {
-			serviceID = s.ID
-
-			break
-		}
-	}
-
-	// no service found with this name
-	if serviceID == "" {
-		return result
-	}
-
-	runningTasks := []types.RunningTask{}
-	runningServiceInstances := []types.RunningServiceInstance{}
-	for _, t := range clusterState.RunningTasks {
-		if t.ServiceID == serviceID {
-			containerStats := cluster.GetContainerStats(t.ContainerID)
-
-			if containerStats == nil {
-				continue
-			}
-
-			runningTasks = append(runningTasks, t)
-
-			runningServiceInstance := types.RunningServiceInstance{
-				Node:           clusterState.RunningActiveNodes[t.NodeID],
-				ContainerStats: *containerStats,
-			}
-
-			runningServiceInstances = append(runningServiceInstances, runningServiceInstance)
-		}
-	}
-
-	if len(runningTasks) == 0 {
-		return result
-	}
-
-	result = types.ServiceState{
-		Service:                 clusterState.Services[serviceID],
-		RunningServiceInstances: runningServiceInstances,
-	}
-
-	return result
-}
-
-// getNewNodesForService
-func getNewNodesForService(serviceState types.ServiceState, allNodes []types.Node, count int) (nodes []string) {
-	nodes = []string{}
-
-	serviceNodesMap := map[string]bool{}
-
-	for _, r := range serviceState.RunningServiceInstances {
-		serviceNodesMap[r.Node.ID] = true
-	}
-
-	for _, n := range allNodes {
-		if _, isServiceOnNode := serviceNodesMap[n.ID]; !isServiceOnNode {
-			nodes = append(nodes, n.ID)
-
-			if len(nodes) == count {
-				break
-			}
-		}
-	}
-
-	return nodes
-}
-
-// categorizeNodesForService
-func categorizeNodesForService(serviceConfig types.ServiceConfig, serviceState types.ServiceState) (
-	healthy []string, sick []string) {
-	healthy = []string{}
-	sick = []string{}
-
-	for _, r := range serviceState.RunningServiceInstances {
-		cpuOk := r.ContainerStats.Usage.CPU <= serviceConfig.ScaleOut.CPU
-		memoryOk := r.ContainerStats.Usage.Memory <= serviceConfig.ScaleOut.Memory
-		if cpuOk && memoryOk {
-			healthy = append(healthy, r.Node.ID)
-		} else {
-			sick = append(sick, r.Node.ID)
-		}
-	}
-
-	return
-}
-
-// getLeastLoadedNodes
-func getLeastLoadedNodes(serviceState types.ServiceState, count int) (nodes []string) {
-	nodes = []string{}
-
-	runningServiceInstances := make([]types.RunningServiceInstance, len(serviceState.RunningServiceInstances))
-	copy(runningServiceInstances, serviceState.RunningServiceInstances)
-
-	sort.SliceStable(runningServiceInstances, func(i, j int) bool {
-		rsi1 := runningServiceInstances[i]
-		rsi2 := runningServiceInstances[j]
-
-		if rsi1.ContainerStats.Usage.CPU == rsi2.ContainerStats.Usage.CPU {
-			return rsi1.ContainerStats.Usage.Memory < rsi2.ContainerStats.Usage.Memory
-		}
-
-		return rsi1.ContainerStats.Usage.CPU < rsi2.ContainerStats.Usage.CPU
-	})
-
-	count = int(math.Max(float64(count), float64(len(runningServiceInstances))))
-
-	for i := 0; i < count; i++ {
-		nodes = append(nodes, runningServiceInstances[i].Node.ID)
-	}
-
-	return nodes
-}
-
-// startServiceOnNodes
-func startServiceOnNodes(serviceConfig types.ServiceConfig, nodes []string) {
-	if len(nodes) == 0 {
-		return
-	}
-
-	log.Infof("starting service %s on %d nodes with label %s", serviceConfig.Name, len(nodes), serviceConfig.NodeLabel)
-
-	for _, n := range nodes {
-		cluster.AddLabelToNode(n, serviceConfig.NodeLabel, "1")
-	}
-}
-
-// stopServiceOnNodes
-func stopServiceOnNodes(serviceConfig types.ServiceConfig, nodes []string) {
-	if len(nodes) == 0 {
-		return
-	}
-
-	log.Infof("stopping service %s on %d nodes with label %s", serviceConfig.Name, len(nodes), serviceConfig.NodeLabel)
-
-	for _, n := range nodes {
-		cluster.RemoveLabelFromNode(n, serviceConfig.NodeLabel)
-	}
-}
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"../cluster"
+	"../config"
+	"../metrics"
+	"../scaler"
+	"../statestore"
+	"../types"
+)
+
+const (
+	// ScaleOut is the direction of a scaling operation that starts new service instances
+	ScaleOut = "out"
+	// ScaleIn is the direction of a scaling operation that stops service instances
+	ScaleIn = "in"
+)
+
+// ScalingDecision represents a scale out/in operation that has been decided for a service and is yet to be applied
+type ScalingDecision struct {
+	ServiceConfig types.ServiceConfig
+	ServiceID     string
+	Direction     string
+	From          int
+	To            int
+	Reason        string
+	// Strategy describes the Scaler, which is not serializable, for reporting
+	Strategy string
+	Scaler   scaler.Scaler `json:"-"`
+}
+
+var (
+	// clock returns the current time and is replaced in tests to simulate the passing of time
+	clock = time.Now
+
+	servicesConfig atomic.Value
+	// scaling holds the staged scalings and the scaling history of the services, only used by the evaluate and act
+	// stages which never run concurrently
+	scaling = types.NewScalingState()
+	// stateStore is where scaling is saved, if anywhere, and savedScaling what was last saved there
+	stateStore   statestore.Store
+	savedScaling types.ScalingState
+)
+
+// evaluation is a round of scaling evaluations, which stages the scalings of the services in scaling and waits for the
+// cooldowns recorded there
+type evaluation struct {
+	scaling types.ScalingState
+	// dryRun makes every scaling due right away, as if it had been staged for its whole period and its cooldown had
+	// ended
+	dryRun bool
+}
+
+// EvaluateServices decides the scaling operations needed by the autoscaled services based on the provided configuration
+//
+// All the services are evaluated against the same cluster state snapshot
+func EvaluateServices(ctx context.Context) []ScalingDecision {
+	clusterState := cluster.GetState()
+	decisions := evaluateServices(ctx, clusterState, &evaluation{scaling: scaling})
+
+	// the samples of the containers that stopped will never be aggregated again
+	runningContainers := map[string]bool{}
+	for _, t := range clusterState.RunningTasks {
+		runningContainers[t.ContainerID] = true
+	}
+
+	metrics.Prune(runningContainers)
+
+	// the services that were removed will never be scaled again
+	if clusterState.Generation > 0 {
+		for _, area := range []map[string]types.ServiceStagedScaling{scaling.ScaleOutStaged, scaling.ScaleInStaged} {
+			for id := range area {
+				if _, ok := clusterState.Services[id]; !ok {
+					scaling.Forget(id)
+				}
+			}
+		}
+
+		for id := range scaling.History {
+			if _, ok := clusterState.Services[id]; !ok {
+				scaling.Forget(id)
+			}
+		}
+	}
+
+	return decisions
+}
+
+// DryRunServices decides the scaling operations the autoscaled services would need once their staging periods and
+// cooldowns are over, without staging or recording anything
+func DryRunServices(ctx context.Context) []ScalingDecision {
+	return evaluateServices(ctx, cluster.GetState(), &evaluation{scaling: scaling.Copy(), dryRun: true})
+}
+
+// evaluateServices decides the scaling operations needed by the autoscaled services in evaluation e
+func evaluateServices(ctx context.Context, clusterState types.ClusterState, e *evaluation) []ScalingDecision {
+	decisions := []ScalingDecision{}
+
+	log.Debugf("evaluating services against cluster state generation %d taken at %s",
+		clusterState.Generation, clusterState.Timestamp.Format(time.RFC3339))
+
+	for _, s := range GetConfig().Services {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if decision := e.scaleService(ctx, clusterState, s); decision != nil {
+			decisions = append(decisions, *decision)
+		}
+	}
+
+	return decisions
+}
+
+// LoadScalingState restores the staged scalings and the scaling history saved in store, where SaveScalingState saves
+// them from then on
+func LoadScalingState(ctx context.Context, store statestore.Store) error {
+	state, err := store.Load(ctx)
+
+	if err != nil {
+		return fmt.Errorf("cannot load the scaling state from the %s: %s", store.Describe(), err)
+	}
+
+	scaling = state
+	savedScaling = state.Copy()
+	stateStore = store
+
+	log.Infof("restored %d staged scalings and the scaling history of %d services from the %s",
+		len(state.ScaleOutStaged)+len(state.ScaleInStaged), len(state.History), store.Describe())
+
+	return nil
+}
+
+// SaveScalingState saves the staged scalings and the scaling history to the store given to LoadScalingState, if any,
+// when they changed since they were last saved
+func SaveScalingState(ctx context.Context) {
+	if stateStore == nil || reflect.DeepEqual(scaling, savedScaling) {
+		return
+	}
+
+	if err := stateStore.Save(ctx, scaling); err != nil {
+		log.Errorf("cannot save the scaling state to the %s: %s", stateStore.Describe(), err)
+
+		return
+	}
+
+	savedScaling = scaling.Copy()
+}
+
+// ApplyDecisions carries out the scaling operations decided by EvaluateServices, recording the ones that succeeded so
+// that their cooldown starts
+//
+// When ctx is cancelled midway the operations that were not carried out, fully or at all, are abandoned and returned
+func ApplyDecisions(ctx context.Context, decisions []ScalingDecision) (abandoned []ScalingDecision) {
+	abandoned = []ScalingDecision{}
+
+	for _, d := range decisions {
+		if ctx.Err() != nil {
+			abandoned = append(abandoned, d)
+
+			continue
+		}
+
+		log.Infof("Scaling %s service %s through its %s: %s", d.Direction, d.ServiceConfig.Name, d.Scaler.Describe(), d.Reason)
+
+		if err := d.Scaler.ScaleTo(ctx, d.To); err != nil {
+			if ctx.Err() != nil {
+				log.Warnf("scaling service %s from %d to %d instances was interrupted: %s", d.ServiceConfig.Name, d.From,
+					d.To, err)
+				abandoned = append(abandoned, d)
+
+				continue
+			}
+
+			log.Errorf("cannot scale service %s from %d to %d instances: %s", d.ServiceConfig.Name, d.From, d.To, err)
+
+			continue
+		}
+
+		recordScaling(d)
+	}
+
+	return abandoned
+}
+
+// CollectStats fetches the stats of the running containers of the configured services from the current cluster state
+// and adds the new samples to the rolling windows of the containers
+//
+// When the usage of the nodes is estimated from their containers, the containers of every other service running on
+// the nodes the configured services may be placed on are fetched too, so that their load counts for the placement
+func CollectStats(ctx context.Context) {
+	clusterState := cluster.GetState()
+
+	configured := map[string]types.ServiceConfig{}
+	for _, s := range GetConfig().Services {
+		configured[s.Name] = s
+	}
+
+	placementNodes := map[string]bool{}
+	if cluster.EstimatesNodeUsage() {
+		placementNodes = getPlacementNodes(clusterState, configured)
+	}
+
+	tasks := []types.RunningTask{}
+	serviceTasks := []types.RunningTask{}
+	for _, t := range clusterState.RunningTasks {
+		_, ok := configured[clusterState.Services[t.ServiceID].Name]
+
+		if ok {
+			serviceTasks = append(serviceTasks, t)
+		}
+
+		if ok || placementNodes[t.NodeID] {
+			tasks = append(tasks, t)
+		}
+	}
+
+	cluster.CollectContainerStats(ctx, tasks)
+
+	// the containers left unfetched when the collect stage times out keep their previous sample, which Record ignores
+	for _, t := range serviceTasks {
+		service := clusterState.Services[t.ServiceID]
+		containerStats := cluster.GetContainerStats(t.ContainerID, getNanoCPUsLimit(configured[service.Name], service))
+
+		if containerStats != nil {
+			metrics.Record(t.ContainerID, metrics.Sample{Timestamp: containerStats.Timestamp, Usage: containerStats.Usage})
+		}
+	}
+}
+
+// getPlacementNodes returns the IDs of the nodes the scalers of the configured services weigh the load of
+func getPlacementNodes(clusterState types.ClusterState, configured map[string]types.ServiceConfig) map[string]bool {
+	result := map[string]bool{}
+
+	for _, service := range clusterState.Services {
+		serviceConfig, ok := configured[service.Name]
+
+		if !ok {
+			continue
+		}
+
+		nodes, err := scaler.GetPlacementNodes(serviceConfig, service, clusterState)
+
+		if err != nil {
+			log.Warnf("cannot list the nodes service %s may be placed on: %s", service.Name, err)
+
+			continue
+		}
+
+		for _, n := range nodes {
+			result[n.ID] = true
+		}
+	}
+
+	return result
+}
+
+// GetServiceStates returns the running state of every service in the active configuration
+func GetServiceStates(ctx context.Context) []types.ServiceState {
+	services := GetConfig().Services
+	states := make([]types.ServiceState, len(services))
+	clusterState := cluster.GetState()
+
+	for i, s := range services {
+		states[i] = getServiceState(ctx, clusterState, s)
+	}
+
+	return states
+}
+
+// GetConfig returns the currently active ServicesConfig object
+func GetConfig() types.ServicesConfig {
+	if c, ok := servicesConfig.Load().(types.ServicesConfig); ok {
+		return c
+	}
+
+	return types.ServicesConfig{}
+}
+
+// UpdateConfig reads a json or yaml configuration file at configPath and atomically swaps the active ServicesConfig object
+//
+// If the file cannot be read or fails validation the previously active configuration is kept and the error is returned
+func UpdateConfig(configPath string) error {
+	newConfig, err := config.Load(configPath)
+
+	if err != nil {
+		return err
+	}
+
+	oldConfig := GetConfig()
+
+	if !reflect.DeepEqual(oldConfig.Stats, newConfig.Stats) {
+		if err := cluster.SetStatsConfig(newConfig.Stats); err != nil {
+			return err
+		}
+
+		log.Infof("container stats are now fetched from the %s source", statsSourceName(newConfig.Stats))
+	}
+
+	servicesConfig.Store(newConfig)
+	metrics.SetRetention(getLongestPeriod(newConfig))
+
+	added, removed, changed := config.Diff(oldConfig, newConfig)
+
+	if len(added) == 0 && len(removed) == 0 && len(changed) == 0 {
+		log.Debugf("configuration loaded from %s without any service changes", configPath)
+
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"added":   added,
+		"removed": removed,
+		"changed": changed,
+	}).Infof("configuration loaded from %s", configPath)
+
+	return nil
+}
+
+// scaleService decides whether a service must be scaled out or in and returns nil if no scaling is needed yet
+func (e *evaluation) scaleService(ctx context.Context, clusterState types.ClusterState,
+	serviceConfig types.ServiceConfig) *ScalingDecision {
+	serviceState := getServiceState(ctx, clusterState, serviceConfig)
+	serviceID := serviceState.Service.ID
+
+	for _, w := range serviceState.Warnings {
+		log.Warnf("Service %s: %s", serviceConfig.Name, w)
+	}
+
+	serviceScaler, err := scaler.New(serviceConfig, serviceState, clusterState)
+
+	if err != nil {
+		log.Warnf("Cannot scale service %s: %s", serviceConfig.Name, err)
+
+		return nil
+	}
+
+	capacity := serviceScaler.CurrentCapacity()
+
+	// the min replicas are restored regardless of the cooldowns
+	if capacity < serviceConfig.MinReplicas {
+		delete(e.scaling.ScaleOutStaged, serviceID)
+		delete(e.scaling.ScaleInStaged, serviceID)
+
+		decision := newScalingDecision(serviceConfig, serviceScaler, capacity, serviceConfig.MinReplicas,
+			fmt.Sprintf("only %d instances are configured", capacity))
+		decision.ServiceID = serviceID
+
+		return decision
+	}
+
+	stagedScaleOut, scaleOutStaged := e.scaling.ScaleOutStaged[serviceID]
+	stagedScaleIn, scaleInStaged := e.scaling.ScaleInStaged[serviceID]
+
+	var decision *ScalingDecision
+
+	switch serviceConfig.Policy {
+	case types.PolicyTargetTracking:
+		decision = e.scaleServiceByTargetTracking(serviceConfig, serviceState, serviceScaler, capacity)
+	case types.PolicyStep:
+		decision = e.scaleServiceBySteps(serviceConfig, serviceState, serviceScaler, capacity)
+	default:
+		decision = e.scaleServiceByThreshold(serviceConfig, serviceState, serviceScaler, capacity)
+	}
+
+	if decision == nil {
+		return nil
+	}
+
+	decision.ServiceID = serviceID
+
+	if remaining := e.getRemainingCooldown(serviceConfig, serviceID, decision.Direction); remaining > 0 {
+		log.Infof("Scaling %s service %s from %d to %d instances delayed by its cooldown for %s", decision.Direction,
+			serviceConfig.Name, decision.From, decision.To, remaining)
+
+		// the scaling stays staged since when it first was so that it is due as soon as the cooldown ends
+		if decision.Direction == ScaleOut && scaleOutStaged {
+			e.scaling.ScaleOutStaged[serviceID] = stagedScaleOut
+		} else if decision.Direction == ScaleIn && scaleInStaged {
+			e.scaling.ScaleInStaged[serviceID] = stagedScaleIn
+		}
+
+		return nil
+	}
+
+	return decision
+}
+
+// scaleServiceByThreshold decides the scaling of a service that keeps min replicas instances below its scale out usage
+func (e *evaluation) scaleServiceByThreshold(serviceConfig types.ServiceConfig, serviceState types.ServiceState,
+	serviceScaler scaler.Scaler, capacity int) *ScalingDecision {
+	serviceID := serviceState.Service.ID
+
+	healthyServiceNodes, sickServiceNodes := categorizeNodesForService(serviceConfig, serviceState)
+	healthyServiceNodesCount, _ := len(healthyServiceNodes), len(sickServiceNodes)
+
+	if healthyServiceNodesCount < serviceConfig.MinReplicas {
+		if capacity >= serviceConfig.MaxReplicas {
+			log.Warnf("Scaling needed to match minimum healthy for service %s but already using max replicas",
+				serviceConfig.Name)
+
+			return nil
+		}
+
+		if !e.stageScaling(e.scaling.ScaleOutStaged, serviceID, serviceConfig.ScaleOut.Period) {
+			return nil
+		}
+
+		target := capacity + serviceConfig.MinReplicas - healthyServiceNodesCount
+
+		if target > serviceConfig.MaxReplicas {
+			log.Warnf("Scaling to %d instances needed for service %s but only %d instances can be used",
+				target,
+				serviceConfig.Name,
+				serviceConfig.MaxReplicas)
+
+			target = serviceConfig.MaxReplicas
+		}
+
+		delete(e.scaling.ScaleOutStaged, serviceID)
+		delete(e.scaling.ScaleInStaged, serviceID)
+
+		return newScalingDecision(serviceConfig, serviceScaler, capacity, target,
+			fmt.Sprintf("only %d instances are healthy", healthyServiceNodesCount))
+	}
+
+	if capacity == serviceConfig.MinReplicas {
+		log.Infof("No scaling needed for service %s", serviceConfig.Name)
+
+		delete(e.scaling.ScaleOutStaged, serviceID)
+		delete(e.scaling.ScaleInStaged, serviceID)
+
+		return nil
+	}
+
+	// at this point we have more healthy instances than needed so we must scale in
+
+	if !e.stageScaling(e.scaling.ScaleInStaged, serviceID, serviceConfig.ScaleIn.Period) {
+		return nil
+	}
+
+	target := capacity - (healthyServiceNodesCount - serviceConfig.MinReplicas)
+
+	if target < serviceConfig.MinReplicas {
+		target = serviceConfig.MinReplicas
+	}
+
+	delete(e.scaling.ScaleOutStaged, serviceID)
+	delete(e.scaling.ScaleInStaged, serviceID)
+
+	return newScalingDecision(serviceConfig, serviceScaler, capacity, target,
+		fmt.Sprintf("%d instances are healthy", healthyServiceNodesCount))
+}
+
+// recordScaling records that a scaling decision has been applied, which starts the cooldown of its direction
+func recordScaling(decision ScalingDecision) {
+	history := scaling.History[decision.ServiceID]
+	history.ServiceID = decision.ServiceID
+
+	if decision.Direction == ScaleOut {
+		history.LastScaleOut = clock().Unix()
+	} else {
+		history.LastScaleIn = clock().Unix()
+	}
+
+	scaling.History[decision.ServiceID] = history
+}
+
+// getRemainingCooldown returns how long a scaling of a service in direction must still wait for
+//
+// A scale out waits for the scale out cooldown after the last scale out, while a scale in waits for the scale in
+// cooldown after the last scaling in either direction, so that it does not undo a scale out that has yet to take effect
+func (e *evaluation) getRemainingCooldown(serviceConfig types.ServiceConfig, serviceID string,
+	direction string) time.Duration {
+	history, ok := e.scaling.History[serviceID]
+
+	if !ok || e.dryRun {
+		return 0
+	}
+
+	cooldown, _ := time.ParseDuration(serviceConfig.ScaleOut.Cooldown)
+	last := history.LastScaleOut
+
+	if direction == ScaleIn {
+		cooldown, _ = time.ParseDuration(serviceConfig.ScaleIn.Cooldown)
+
+		if history.LastScaleIn > last {
+			last = history.LastScaleIn
+		}
+	}
+
+	if last == 0 {
+		return 0
+	}
+
+	return time.Unix(last, 0).Add(cooldown).Sub(clock())
+}
+
+// stageScaling stages a scaling of a service in a staging area and reports whether it has been staged for at least
+// period, in which case it is due
+//
+// A zero period or a dry run makes the scaling due right away without staging it
+func (e *evaluation) stageScaling(area map[string]types.ServiceStagedScaling, serviceID string, period string) bool {
+	stagingPeriod, _ := time.ParseDuration(period)
+
+	if stagingPeriod.Seconds() == 0.0 || e.dryRun {
+		return true
+	}
+
+	s, ok := area[serviceID]
+
+	if !ok {
+		area[serviceID] = types.ServiceStagedScaling{
+			ServiceID:       serviceID,
+			StagedTimestamp: clock().Unix(),
+		}
+
+		return false
+	}
+
+	return float64(clock().Unix()-s.StagedTimestamp) >= stagingPeriod.Seconds()
+}
+
+// newScalingDecision builds the decision to scale a service from capacity to target instances and returns nil if they
+// are the same
+func newScalingDecision(serviceConfig types.ServiceConfig, serviceScaler scaler.Scaler, capacity int, target int,
+	reason string) *ScalingDecision {
+	if target == capacity {
+		return nil
+	}
+
+	direction := ScaleOut
+	if target < capacity {
+		direction = ScaleIn
+	}
+
+	return &ScalingDecision{
+		ServiceConfig: serviceConfig,
+		Direction:     direction,
+		From:          capacity,
+		To:            target,
+		Reason:        reason,
+		Scaler:        serviceScaler,
+		Strategy:      serviceScaler.Describe(),
+	}
+}
+
+// getServiceState
+func getServiceState(ctx context.Context, clusterState types.ClusterState, serviceConfig types.ServiceConfig) types.ServiceState {
+	var result types.ServiceState
+
+	var serviceID string
+	for _, s := range clusterState.Services {
+		if s.Name == serviceConfig.Name {
+			serviceID = s.ID
+
+			break
+		}
+	}
+
+	// no service found with this name
+	if serviceID == "" {
+		return result
+	}
+
+	warnings := []string{}
+
+	nanoCPUsLimit := getNanoCPUsLimit(serviceConfig, clusterState.Services[serviceID])
+
+	if serviceConfig.CPUMode == types.CPUModeLimit && nanoCPUsLimit == 0 {
+		warnings = append(warnings, "cpu_mode is limit but the service has no cpu limit, its cpu usage is "+
+			"relative to a single host cpu instead")
+	}
+
+	scaleOutPeriod, _ := time.ParseDuration(serviceConfig.ScaleOut.Period)
+	scaleInPeriod, _ := time.ParseDuration(serviceConfig.ScaleIn.Period)
+
+	runningServiceInstances := []types.RunningServiceInstance{}
+	for _, t := range clusterState.RunningTasks {
+		if t.ServiceID == serviceID {
+			containerStats := cluster.GetContainerStats(t.ContainerID, nanoCPUsLimit)
+
+			if containerStats == nil {
+				continue
+			}
+
+			now := clock()
+			scaleOutWindow := metrics.Aggregate(t.ContainerID, scaleOutPeriod, now)
+			scaleInWindow := metrics.Aggregate(t.ContainerID, scaleInPeriod, now)
+
+			runningServiceInstance := types.RunningServiceInstance{
+				Node:            clusterState.RunningActiveNodes[t.NodeID],
+				Task:            t,
+				ContainerStats:  *containerStats,
+				ScaleOutUsage:   scaleOutWindow.Statistic(serviceConfig.ScaleOut.Statistic),
+				ScaleOutSamples: scaleOutWindow.Samples,
+				ScaleInUsage:    scaleInWindow.Statistic(serviceConfig.ScaleIn.Statistic),
+				ScaleInSamples:  scaleInWindow.Samples,
+			}
+
+			runningServiceInstances = append(runningServiceInstances, runningServiceInstance)
+		}
+	}
+
+	result = types.ServiceState{
+		Service:                 clusterState.Services[serviceID],
+		RunningServiceInstances: runningServiceInstances,
+		ScalingHistory:          scaling.History[serviceID],
+		Warnings:                warnings,
+	}
+
+	return result
+}
+
+// getNanoCPUsLimit returns the cpu limit the cpu usage of the containers of a service is relative to, or 0 when it is
+// relative to a single host cpu
+func getNanoCPUsLimit(serviceConfig types.ServiceConfig, service types.Service) int64 {
+	if serviceConfig.CPUMode != types.CPUModeLimit {
+		return 0
+	}
+
+	return service.NanoCPUsLimit
+}
+
+// statsSourceName
+func statsSourceName(statsConfig types.StatsConfig) string {
+	if statsConfig.Source == "" {
+		return types.StatsSourceLocal
+	}
+
+	return statsConfig.Source
+}
+
+// getLongestPeriod returns the longest scale out or scale in period of the configured services
+func getLongestPeriod(servicesConfig types.ServicesConfig) time.Duration {
+	var longest time.Duration
+
+	for _, s := range servicesConfig.Services {
+		for _, p := range []string{s.ScaleOut.Period, s.ScaleIn.Period} {
+			if period, err := time.ParseDuration(p); err == nil && period > longest {
+				longest = period
+			}
+		}
+	}
+
+	return longest
+}
+
+// categorizeNodesForService judges the health of every instance of a service from its usage aggregated over the
+// scale out period, so that a single spike does not make an instance sick
+func categorizeNodesForService(serviceConfig types.ServiceConfig, serviceState types.ServiceState) (
+	healthy []string, sick []string) {
+	healthy = []string{}
+	sick = []string{}
+
+	for _, r := range serviceState.RunningServiceInstances {
+		cpuOk := r.ScaleOutUsage.CPU <= serviceConfig.ScaleOut.CPU
+		memoryOk := r.ScaleOutUsage.Memory <= serviceConfig.ScaleOut.Memory
+		if cpuOk && memoryOk {
+			healthy = append(healthy, r.Node.ID)
+		} else {
+			sick = append(sick, r.Node.ID)
+		}
+	}
+
+	return
+}
+
+// getAverageUsage averages the usage of the instances of a service, which must not be empty
+func getAverageUsage(instances []types.RunningServiceInstance,
+	usage func(types.RunningServiceInstance) types.ContainerResourceUsage) types.ContainerResourceUsage {
+	var result types.ContainerResourceUsage
+
+	for _, r := range instances {
+		u := usage(r)
+		result.CPU += u.CPU
+		result.Memory += u.Memory
+	}
+
+	result.CPU /= float64(len(instances))
+	result.Memory /= float64(len(instances))
+
+	return result
+}
@@ -0,0 +1,1389 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	log "github.com/sirupsen/logrus"
+
+	"../client"
+	"../cluster"
+	"../fakeswarm"
+	"../metrics"
+	"../statestore"
+	autoscalerTypes "../types"
+)
+
+var (
+	idle       = fakeswarm.Usage{CPU: 5, Memory: 10}
+	overloaded = fakeswarm.Usage{CPU: 90, Memory: 10}
+)
+
+// fixture is a simulated swarm running the service under test
+type fixture struct {
+	swarm     *fakeswarm.Swarm
+	nodes     map[string]string
+	serviceID string
+}
+
+// scalingRound is a single evaluation of the service under test, whose decision is applied right away
+type scalingRound struct {
+	// elapsed is the simulated time passed since the previous round
+	elapsed time.Duration
+	// prepare changes the simulated swarm before the cluster state is refreshed
+	prepare       func(f *fixture)
+	wantDirection string
+	// wantErr is whether applying the scaling fails, in which case it is not recorded
+	wantErr bool
+	// wantStaged is whether a scaling of the service is waiting for its period to expire after the round
+	wantStaged bool
+	// wantLabeled are the hostnames of the nodes carrying the service label after the round, for node_label services
+	wantLabeled []string
+	// wantReplicas is the replica count of the service after the round, for replicas services
+	wantReplicas uint64
+}
+
+// replicasScenario is a replicas service running on 3 nodes through a sequence of scaling rounds
+type replicasScenario struct {
+	name     string
+	replicas uint64
+	usage    fakeswarm.Usage
+	config   autoscalerTypes.ServiceConfig
+	rounds   []scalingRound
+}
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+
+	os.Exit(m.Run())
+}
+
+func TestScaleServiceByNodeLabel(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		nodes   []string
+		labeled []string
+		drained []string
+		usage   fakeswarm.Usage
+		// reservations are the resources reserved by every task of the service
+		reservations *swarm.Resources
+		// reserved are the resources reserved on nodes by the tasks of other services, by hostname
+		reserved map[string]swarm.Resources
+		// busy is the usage of the tasks a service missing from the configuration runs on nodes, by hostname
+		busy map[string]fakeswarm.Usage
+		// constraints are the placement constraints of the service besides the one on its label
+		constraints []string
+		config      autoscalerTypes.ServiceConfig
+		rounds      []scalingRound
+	}{
+		{
+			name:    "below min replicas",
+			nodes:   []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			labeled: []string{"worker-1"},
+			usage:   idle,
+			config:  nodeLabelConfig(3, 4, "1m"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantLabeled: []string{"worker-1", "worker-2", "worker-3"}},
+				{wantLabeled: []string{"worker-1", "worker-2", "worker-3"}},
+			},
+		},
+		{
+			name:    "sustained cpu overload",
+			nodes:   []string{"worker-1", "worker-2", "worker-3", "worker-4", "worker-5"},
+			labeled: []string{"worker-1", "worker-2"},
+			usage:   overloaded,
+			config:  nodeLabelConfig(2, 4, "1m"),
+			rounds: []scalingRound{
+				{wantStaged: true, wantLabeled: []string{"worker-1", "worker-2"}},
+				{elapsed: 30 * time.Second, wantStaged: true, wantLabeled: []string{"worker-1", "worker-2"}},
+				{
+					elapsed:       30 * time.Second,
+					wantDirection: ScaleOut,
+					wantLabeled:   []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+				},
+			},
+		},
+		{
+			name:    "overload ending before the period expires",
+			nodes:   []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			labeled: []string{"worker-1", "worker-2"},
+			usage:   overloaded,
+			config:  nodeLabelConfig(2, 4, "1m"),
+			rounds: []scalingRound{
+				{wantStaged: true, wantLabeled: []string{"worker-1", "worker-2"}},
+				{
+					elapsed:     30 * time.Second,
+					prepare:     func(f *fixture) { f.swarm.SetServiceUsage(f.serviceID, idle) },
+					wantLabeled: []string{"worker-1", "worker-2"},
+				},
+				{
+					elapsed:     30 * time.Second,
+					prepare:     func(f *fixture) { f.swarm.SetServiceUsage(f.serviceID, overloaded) },
+					wantStaged:  true,
+					wantLabeled: []string{"worker-1", "worker-2"},
+				},
+				{elapsed: 30 * time.Second, wantStaged: true, wantLabeled: []string{"worker-1", "worker-2"}},
+				{
+					elapsed:       30 * time.Second,
+					wantDirection: ScaleOut,
+					wantLabeled:   []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+				},
+			},
+		},
+		{
+			name:    "short cpu spike",
+			nodes:   []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			labeled: []string{"worker-1", "worker-2"},
+			usage:   idle,
+			config:  nodeLabelConfig(2, 4, "1m"),
+			rounds: []scalingRound{
+				{wantLabeled: []string{"worker-1", "worker-2"}},
+				{elapsed: 20 * time.Second, wantLabeled: []string{"worker-1", "worker-2"}},
+				{elapsed: 20 * time.Second, wantLabeled: []string{"worker-1", "worker-2"}},
+				{
+					elapsed:     10 * time.Second,
+					prepare:     func(f *fixture) { f.swarm.SetServiceUsage(f.serviceID, overloaded) },
+					wantLabeled: []string{"worker-1", "worker-2"},
+				},
+				{elapsed: 5 * time.Second, wantLabeled: []string{"worker-1", "worker-2"}},
+			},
+		},
+		{
+			name:    "scale in to min replicas",
+			nodes:   []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			labeled: []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			usage:   idle,
+			config:  nodeLabelConfig(2, 4, "1m"),
+			rounds: []scalingRound{
+				{
+					prepare: func(f *fixture) {
+						f.setNodeUsage("worker-1", fakeswarm.Usage{CPU: 30, Memory: 10})
+						f.setNodeUsage("worker-2", fakeswarm.Usage{CPU: 5, Memory: 10})
+						f.setNodeUsage("worker-3", fakeswarm.Usage{CPU: 20, Memory: 10})
+						f.setNodeUsage("worker-4", fakeswarm.Usage{CPU: 2, Memory: 10})
+					},
+					wantStaged:  true,
+					wantLabeled: []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+				},
+				{
+					elapsed:     30 * time.Second,
+					wantStaged:  true,
+					wantLabeled: []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+				},
+				{elapsed: 30 * time.Second, wantDirection: ScaleIn, wantLabeled: []string{"worker-1", "worker-3"}},
+				{elapsed: time.Minute, wantLabeled: []string{"worker-1", "worker-3"}},
+			},
+		},
+		{
+			name:     "scale in on the nodes with the most other load",
+			nodes:    []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			labeled:  []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			reserved: map[string]swarm.Resources{"worker-2": {NanoCPUs: 15e8}, "worker-4": {MemoryBytes: 3 << 30}},
+			usage:    idle,
+			config:   withScaleInStrategy(nodeLabelConfig(2, 4, "0s"), autoscalerTypes.ScaleInStrategyMostOtherLoad),
+			rounds: []scalingRound{
+				{wantDirection: ScaleIn, wantLabeled: []string{"worker-1", "worker-3"}},
+			},
+		},
+		{
+			name:    "no eligible nodes",
+			nodes:   []string{"worker-1", "worker-2", "worker-3"},
+			labeled: []string{"worker-1"},
+			drained: []string{"worker-2", "worker-3"},
+			usage:   idle,
+			config:  nodeLabelConfig(3, 3, "0s"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantErr: true, wantLabeled: []string{"worker-1"}},
+			},
+		},
+		{
+			name:    "label update failing",
+			nodes:   []string{"worker-1", "worker-2", "worker-3", "worker-4", "worker-5"},
+			labeled: []string{"worker-1", "worker-2"},
+			usage:   overloaded,
+			config:  withCooldowns(nodeLabelConfig(2, 5, "0s"), "5m", "5m"),
+			rounds: []scalingRound{
+				{
+					prepare: func(f *fixture) {
+						f.swarm.SetNodeUpdateHook(func(ctx context.Context, nodeID string) error {
+							if nodeID == f.nodes["worker-3"] {
+								return fmt.Errorf("node is locked")
+							}
+
+							return nil
+						})
+					},
+					wantDirection: ScaleOut,
+					wantErr:       true,
+					wantLabeled:   []string{"worker-1", "worker-2", "worker-4"},
+				},
+				// the failed scaling did not start the cooldown
+				{
+					elapsed:       10 * time.Second,
+					prepare:       func(f *fixture) { f.swarm.SetNodeUpdateHook(nil) },
+					wantDirection: ScaleOut,
+					wantLabeled:   []string{"worker-1", "worker-2", "worker-3", "worker-4", "worker-5"},
+				},
+			},
+		},
+		{
+			name:     "least reserved nodes first",
+			nodes:    []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			labeled:  []string{"worker-1"},
+			reserved: map[string]swarm.Resources{"worker-2": {NanoCPUs: 15e8}, "worker-3": {MemoryBytes: 1 << 30}},
+			usage:    idle,
+			config:   nodeLabelConfig(3, 4, "1m"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantLabeled: []string{"worker-1", "worker-3", "worker-4"}},
+			},
+		},
+		{
+			name:         "nodes not fitting the reservations",
+			nodes:        []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			labeled:      []string{"worker-1"},
+			reservations: &swarm.Resources{MemoryBytes: 3 << 30},
+			reserved:     map[string]swarm.Resources{"worker-2": {MemoryBytes: 2 << 30}},
+			usage:        idle,
+			config:       nodeLabelConfig(4, 4, "1m"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantErr: true, wantLabeled: []string{"worker-1", "worker-3", "worker-4"}},
+			},
+		},
+		{
+			name:        "nodes not satisfying the placement constraints",
+			nodes:       []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			labeled:     []string{"worker-1", "worker-2"},
+			constraints: []string{"node.hostname != worker-2", "node.role == worker"},
+			usage:       idle,
+			config:      nodeLabelConfig(3, 4, "1m"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantLabeled: []string{"worker-1", "worker-2", "worker-3", "worker-4"}},
+				{wantLabeled: []string{"worker-1", "worker-2", "worker-3", "worker-4"}},
+			},
+		},
+		{
+			name:    "scale in with instances missing stats",
+			nodes:   []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			labeled: []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			usage:   idle,
+			config: autoscalerTypes.ServiceConfig{
+				Name:           "portainer",
+				MinReplicas:    1,
+				MaxReplicas:    4,
+				NodeLabel:      "portainer",
+				Policy:         autoscalerTypes.PolicyTargetTracking,
+				TargetTracking: autoscalerTypes.TargetTrackingConfig{CPU: 50, Memory: 50},
+			},
+			rounds: []scalingRound{
+				{
+					// the instances without stats cannot be picked, so only two of the three nodes are unlabeled
+					prepare: func(f *fixture) {
+						f.setNodeStatsFailure("worker-2", fmt.Errorf("connection refused"))
+						f.setNodeStatsFailure("worker-3", fmt.Errorf("connection refused"))
+					},
+					wantDirection: ScaleIn,
+					wantErr:       true,
+					wantLabeled:   []string{"worker-2", "worker-3"},
+				},
+				{
+					prepare: func(f *fixture) {
+						f.setNodeStatsFailure("worker-2", nil)
+						f.setNodeStatsFailure("worker-3", nil)
+					},
+					wantDirection: ScaleIn,
+					wantLabeled:   []string{"worker-3"},
+				},
+			},
+		},
+		{
+			name:    "placement on the node least loaded by services missing from the configuration",
+			nodes:   []string{"worker-1", "worker-2", "worker-3"},
+			labeled: []string{"worker-1"},
+			busy:    map[string]fakeswarm.Usage{"worker-2": {CPU: 90, Memory: 10}, "worker-3": {CPU: 10, Memory: 10}},
+			usage:   idle,
+			config:  nodeLabelConfig(2, 3, "1m"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantLabeled: []string{"worker-1", "worker-3"}},
+			},
+		},
+		{
+			name:    "max replicas reached",
+			nodes:   []string{"worker-1", "worker-2", "worker-3", "worker-4"},
+			labeled: []string{"worker-1", "worker-2", "worker-3"},
+			usage:   overloaded,
+			config:  nodeLabelConfig(2, 3, "0s"),
+			rounds: []scalingRound{
+				{wantLabeled: []string{"worker-1", "worker-2", "worker-3"}},
+				{elapsed: time.Hour, wantLabeled: []string{"worker-1", "worker-2", "worker-3"}},
+			},
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			f := &fixture{swarm: fakeswarm.New(), nodes: map[string]string{}}
+
+			labeled := map[string]bool{}
+			for _, hostname := range s.labeled {
+				labeled[hostname] = true
+			}
+
+			for _, hostname := range s.nodes {
+				labels := map[string]string{}
+				if labeled[hostname] {
+					labels[s.config.NodeLabel] = "1"
+				}
+
+				f.nodes[hostname] = f.swarm.AddNode(hostname, swarm.NodeRoleWorker, labels)
+			}
+
+			for _, hostname := range s.drained {
+				f.swarm.SetNodeAvailability(f.nodes[hostname], swarm.NodeAvailabilityDrain)
+			}
+
+			for hostname, reserved := range s.reserved {
+				reserved := reserved
+				f.swarm.AddService(swarm.ServiceSpec{
+					Annotations: swarm.Annotations{Name: "reserving-" + hostname},
+					TaskTemplate: swarm.TaskSpec{
+						Resources: &swarm.ResourceRequirements{Reservations: &reserved},
+						Placement: &swarm.Placement{Constraints: []string{"node.hostname == " + hostname}},
+					},
+					Mode: swarm.ServiceMode{Global: &swarm.GlobalService{}},
+				})
+			}
+
+			for hostname, usage := range s.busy {
+				busyID := f.swarm.AddService(swarm.ServiceSpec{
+					Annotations: swarm.Annotations{Name: "busy-" + hostname},
+					TaskTemplate: swarm.TaskSpec{
+						Placement: &swarm.Placement{Constraints: []string{"node.hostname == " + hostname}},
+					},
+					Mode: swarm.ServiceMode{Global: &swarm.GlobalService{}},
+				})
+				f.swarm.SetServiceUsage(busyID, usage)
+			}
+
+			f.serviceID = f.swarm.AddService(swarm.ServiceSpec{
+				Annotations: swarm.Annotations{Name: s.config.Name},
+				TaskTemplate: swarm.TaskSpec{
+					Resources: &swarm.ResourceRequirements{Reservations: s.reservations},
+					Placement: &swarm.Placement{
+						Constraints: append([]string{"node.labels." + s.config.NodeLabel + " == 1"}, s.constraints...),
+					},
+				},
+				Mode: swarm.ServiceMode{Global: &swarm.GlobalService{}},
+			})
+			f.swarm.SetServiceUsage(f.serviceID, s.usage)
+
+			runScalingRounds(t, f, s.config, s.rounds)
+		})
+	}
+}
+
+func TestScaleServiceByReplicas(t *testing.T) {
+	runReplicasScenarios(t, []replicasScenario{
+		{
+			name:     "below min replicas",
+			replicas: 1,
+			usage:    idle,
+			config:   replicasConfig(3, 5, "1m"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantReplicas: 3},
+				{wantReplicas: 3},
+			},
+		},
+		{
+			name:     "sustained cpu overload",
+			replicas: 2,
+			usage:    overloaded,
+			config:   replicasConfig(2, 5, "1m"),
+			rounds: []scalingRound{
+				{wantStaged: true, wantReplicas: 2},
+				{elapsed: time.Minute, wantDirection: ScaleOut, wantReplicas: 4},
+			},
+		},
+		{
+			name:     "scale in to min replicas",
+			replicas: 4,
+			usage:    idle,
+			config:   replicasConfig(1, 5, "0s"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleIn, wantReplicas: 1},
+				{wantReplicas: 1},
+			},
+		},
+		{
+			name:     "max replicas reached",
+			replicas: 3,
+			usage:    overloaded,
+			config:   replicasConfig(2, 3, "0s"),
+			rounds: []scalingRound{
+				{wantReplicas: 3},
+			},
+		},
+		{
+			name:     "scale out cooldown",
+			replicas: 2,
+			usage:    overloaded,
+			config:   withCooldowns(replicasConfig(2, 8, "0s"), "2m", "0s"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantReplicas: 4},
+				{elapsed: time.Minute, wantReplicas: 4},
+				{elapsed: time.Minute, wantDirection: ScaleOut, wantReplicas: 6},
+			},
+		},
+		{
+			name:     "scale in cooldown after a scale out",
+			replicas: 2,
+			usage:    overloaded,
+			config:   withCooldowns(replicasConfig(2, 8, "0s"), "0s", "5m"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantReplicas: 4},
+				{
+					elapsed:      time.Minute,
+					prepare:      func(f *fixture) { f.swarm.SetServiceUsage(f.serviceID, idle) },
+					wantReplicas: 4,
+				},
+				{elapsed: 4 * time.Minute, wantDirection: ScaleIn, wantReplicas: 2},
+			},
+		},
+		{
+			name:     "staged scaling due when the cooldown ends",
+			replicas: 2,
+			usage:    overloaded,
+			config:   withCooldowns(replicasConfig(2, 8, "1m"), "3m30s", "0s"),
+			rounds: []scalingRound{
+				{wantStaged: true, wantReplicas: 2},
+				{elapsed: time.Minute, wantDirection: ScaleOut, wantReplicas: 4},
+				{elapsed: time.Minute, wantStaged: true, wantReplicas: 4},
+				{elapsed: time.Minute, wantStaged: true, wantReplicas: 4},
+				{elapsed: time.Minute, wantStaged: true, wantReplicas: 4},
+				{elapsed: 30 * time.Second, wantDirection: ScaleOut, wantReplicas: 6},
+			},
+		},
+	})
+}
+
+func TestScaleServiceByTargetTracking(t *testing.T) {
+	runReplicasScenarios(t, []replicasScenario{
+		{
+			name:     "cpu above target",
+			replicas: 2,
+			usage:    fakeswarm.Usage{CPU: 90, Memory: 10},
+			config:   targetTrackingConfig(1, 6, 45, 0, "1m"),
+			rounds: []scalingRound{
+				{wantStaged: true, wantReplicas: 2},
+				{elapsed: time.Minute, wantDirection: ScaleOut, wantReplicas: 4},
+				{elapsed: time.Minute, wantStaged: true, wantReplicas: 4},
+				{elapsed: time.Minute, wantDirection: ScaleOut, wantReplicas: 6},
+				{elapsed: time.Minute, wantReplicas: 6},
+			},
+		},
+		{
+			name:     "memory above target",
+			replicas: 3,
+			usage:    fakeswarm.Usage{CPU: 10, Memory: 70},
+			config:   targetTrackingConfig(1, 10, 50, 40, "0s"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantReplicas: 6},
+			},
+		},
+		{
+			name:     "usage within tolerance",
+			replicas: 3,
+			usage:    fakeswarm.Usage{CPU: 54, Memory: 10},
+			config:   targetTrackingConfig(1, 10, 50, 0, "0s"),
+			rounds: []scalingRound{
+				{wantReplicas: 3},
+			},
+		},
+		{
+			name:     "usage below target",
+			replicas: 5,
+			usage:    fakeswarm.Usage{CPU: 10, Memory: 10},
+			config:   targetTrackingConfig(2, 10, 40, 0, "1m"),
+			rounds: []scalingRound{
+				{wantStaged: true, wantReplicas: 5},
+				{elapsed: 30 * time.Second, wantStaged: true, wantReplicas: 5},
+				{elapsed: 30 * time.Second, wantDirection: ScaleIn, wantReplicas: 2},
+			},
+		},
+	})
+}
+
+func TestScaleServiceByTargetTrackingWithoutSamples(t *testing.T) {
+	tests := []struct {
+		name          string
+		instances     []autoscalerTypes.RunningServiceInstance
+		wantDirection string
+		wantTo        int
+	}{
+		{
+			name:      "new instance at target",
+			instances: []autoscalerTypes.RunningServiceInstance{instance(48, 6), instance(48, 6), instance(0, 0)},
+		},
+		{
+			name:          "new instance above target",
+			instances:     []autoscalerTypes.RunningServiceInstance{instance(90, 6), instance(90, 6), instance(0, 0)},
+			wantDirection: ScaleOut,
+			wantTo:        6,
+		},
+		{
+			name:          "new instance below target",
+			instances:     []autoscalerTypes.RunningServiceInstance{instance(10, 6), instance(10, 6), instance(0, 0)},
+			wantDirection: ScaleIn,
+			wantTo:        1,
+		},
+		{
+			name:      "no instance with samples",
+			instances: []autoscalerTypes.RunningServiceInstance{instance(0, 0), instance(0, 0), instance(0, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &evaluation{scaling: autoscalerTypes.NewScalingState()}
+			serviceState := autoscalerTypes.ServiceState{
+				Service:                 autoscalerTypes.Service{ID: "api", Name: "api"},
+				RunningServiceInstances: tt.instances,
+			}
+
+			decision := e.scaleServiceByTargetTracking(targetTrackingConfig(1, 10, 50, 0, "0s"), serviceState,
+				&stubScaler{}, len(tt.instances))
+
+			var direction string
+			var to int
+			if decision != nil {
+				direction, to = decision.Direction, decision.To
+			}
+
+			if direction != tt.wantDirection || to != tt.wantTo {
+				t.Errorf("got scaling %q to %d instances, want %q to %d", direction, to, tt.wantDirection, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestScaleServiceBySteps(t *testing.T) {
+	runReplicasScenarios(t, []replicasScenario{
+		{
+			name:     "above the first step",
+			replicas: 2,
+			usage:    fakeswarm.Usage{CPU: 70, Memory: 10},
+			config:   stepConfig(2, 8, "1m"),
+			rounds: []scalingRound{
+				{wantStaged: true, wantReplicas: 2},
+				{elapsed: time.Minute, wantDirection: ScaleOut, wantReplicas: 3},
+			},
+		},
+		{
+			name:     "above the largest step",
+			replicas: 2,
+			usage:    fakeswarm.Usage{CPU: 90, Memory: 10},
+			config:   stepConfig(2, 8, "0s"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantReplicas: 5},
+			},
+		},
+		{
+			name:     "step capped by max replicas",
+			replicas: 2,
+			usage:    fakeswarm.Usage{CPU: 90, Memory: 10},
+			config:   stepConfig(2, 4, "0s"),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantReplicas: 4},
+				{wantReplicas: 4},
+			},
+		},
+		{
+			name:     "between the steps",
+			replicas: 3,
+			usage:    fakeswarm.Usage{CPU: 40, Memory: 10},
+			config:   stepConfig(2, 8, "0s"),
+			rounds: []scalingRound{
+				{wantReplicas: 3},
+			},
+		},
+		{
+			name:     "below the scale in step",
+			replicas: 4,
+			usage:    fakeswarm.Usage{CPU: 10, Memory: 10},
+			config:   stepConfig(2, 8, "1m"),
+			rounds: []scalingRound{
+				{wantStaged: true, wantReplicas: 4},
+				{elapsed: time.Minute, wantDirection: ScaleIn, wantReplicas: 3},
+				{elapsed: time.Minute, wantStaged: true, wantReplicas: 3},
+				{elapsed: time.Minute, wantDirection: ScaleIn, wantReplicas: 2},
+				{elapsed: time.Minute, wantReplicas: 2},
+			},
+		},
+		{
+			name:     "largest of several crossed scale out steps",
+			replicas: 2,
+			usage:    fakeswarm.Usage{CPU: 90, Memory: 10},
+			config: withSteps(stepConfig(2, 10, "0s"),
+				[]autoscalerTypes.ScalingStep{{CPU: 60, Change: 1}, {CPU: 85, Change: 2}, {CPU: 70, Change: 4}}, nil),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantReplicas: 6},
+			},
+		},
+		{
+			name:     "largest of several crossed scale in steps",
+			replicas: 6,
+			usage:    fakeswarm.Usage{CPU: 10, Memory: 10},
+			config: withSteps(stepConfig(2, 10, "0s"), nil,
+				[]autoscalerTypes.ScalingStep{{CPU: 30, Change: 1}, {CPU: 15, Change: 3}, {CPU: 5, Change: 4}}),
+			rounds: []scalingRound{
+				{wantDirection: ScaleIn, wantReplicas: 3},
+			},
+		},
+		{
+			name:     "above a memory step",
+			replicas: 2,
+			usage:    fakeswarm.Usage{CPU: 10, Memory: 70},
+			config: withSteps(stepConfig(2, 10, "0s"),
+				[]autoscalerTypes.ScalingStep{{CPU: 60, Change: 1}, {Memory: 60, Change: 2}}, nil),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantReplicas: 4},
+			},
+		},
+		{
+			name:     "memory step larger than the crossed cpu step",
+			replicas: 2,
+			usage:    fakeswarm.Usage{CPU: 90, Memory: 70},
+			config: withSteps(stepConfig(2, 10, "0s"),
+				[]autoscalerTypes.ScalingStep{{CPU: 60, Change: 1}, {Memory: 60, Change: 3}}, nil),
+			rounds: []scalingRound{
+				{wantDirection: ScaleOut, wantReplicas: 5},
+			},
+		},
+		{
+			name:     "below a memory scale in step",
+			replicas: 4,
+			usage:    fakeswarm.Usage{CPU: 50, Memory: 10},
+			config:   withSteps(stepConfig(2, 10, "0s"), nil, []autoscalerTypes.ScalingStep{{Memory: 20, Change: 1}}),
+			rounds: []scalingRound{
+				{wantDirection: ScaleIn, wantReplicas: 3},
+			},
+		},
+		{
+			name:     "above the cpu but not the memory of a scale in step",
+			replicas: 4,
+			usage:    fakeswarm.Usage{CPU: 10, Memory: 30},
+			config: withSteps(stepConfig(2, 10, "0s"), nil,
+				[]autoscalerTypes.ScalingStep{{CPU: 20, Memory: 20, Change: 1}}),
+			rounds: []scalingRound{
+				{wantReplicas: 4},
+			},
+		},
+	})
+
+	tests := []struct {
+		name          string
+		instances     []autoscalerTypes.RunningServiceInstance
+		wantDirection string
+		wantTo        int
+	}{
+		{
+			name:      "new instance between the steps",
+			instances: []autoscalerTypes.RunningServiceInstance{instance(25, 6), instance(25, 6), instance(0, 0)},
+		},
+		{
+			name:          "new instance above the largest step",
+			instances:     []autoscalerTypes.RunningServiceInstance{instance(90, 6), instance(90, 6), instance(0, 0)},
+			wantDirection: ScaleOut,
+			wantTo:        6,
+		},
+		{
+			name:          "new instance below the scale in step",
+			instances:     []autoscalerTypes.RunningServiceInstance{instance(10, 6), instance(10, 6), instance(0, 0)},
+			wantDirection: ScaleIn,
+			wantTo:        2,
+		},
+		{
+			name:      "no instance with samples",
+			instances: []autoscalerTypes.RunningServiceInstance{instance(0, 0), instance(0, 0), instance(0, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &evaluation{scaling: autoscalerTypes.NewScalingState()}
+			serviceState := autoscalerTypes.ServiceState{
+				Service:                 autoscalerTypes.Service{ID: "api", Name: "api"},
+				RunningServiceInstances: tt.instances,
+			}
+
+			decision := e.scaleServiceBySteps(stepConfig(1, 10, "0s"), serviceState, &stubScaler{}, len(tt.instances))
+
+			var direction string
+			var to int
+			if decision != nil {
+				direction, to = decision.Direction, decision.To
+			}
+
+			if direction != tt.wantDirection || to != tt.wantTo {
+				t.Errorf("got scaling %q to %d instances, want %q to %d", direction, to, tt.wantDirection, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestScalingStateSurvivesRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	store := statestore.NewFileStore(filepath.Join(dir, "state.json"))
+	defer func() { stateStore = nil }()
+
+	// restart saves the scaling state and loads it back into an autoscaler that lost it
+	restart := func(f *fixture) {
+		SaveScalingState(context.Background())
+		scaling = autoscalerTypes.NewScalingState()
+
+		if err := LoadScalingState(context.Background(), store); err != nil {
+			t.Fatalf("cannot load the scaling state: %s", err)
+		}
+	}
+
+	f := &fixture{swarm: fakeswarm.New(), nodes: map[string]string{}}
+	f.nodes["worker-1"] = f.swarm.AddNode("worker-1", swarm.NodeRoleWorker, nil)
+
+	replicas := uint64(2)
+	f.serviceID = f.swarm.AddService(swarm.ServiceSpec{
+		Annotations: swarm.Annotations{Name: "api"},
+		Mode:        swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+	})
+	f.swarm.SetServiceUsage(f.serviceID, overloaded)
+
+	if err := LoadScalingState(context.Background(), store); err != nil {
+		t.Fatal(err)
+	}
+
+	runScalingRounds(t, f, withCooldowns(replicasConfig(2, 8, "1m"), "2m", "0s"), []scalingRound{
+		{wantStaged: true, wantReplicas: 2},
+		{elapsed: time.Minute, prepare: restart, wantDirection: ScaleOut, wantReplicas: 4},
+		{elapsed: time.Minute, prepare: restart, wantStaged: true, wantReplicas: 4},
+		{elapsed: time.Minute, prepare: restart, wantDirection: ScaleOut, wantReplicas: 6},
+	})
+}
+
+func TestUpdateConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	defer servicesConfig.Store(autoscalerTypes.ServicesConfig{})
+
+	path := filepath.Join(dir, "services.yml")
+	service := func(name string, maxReplicas int) string {
+		return fmt.Sprintf("  - name: %s\n    max_replicas: %d\n    node_label: %s\n", name, maxReplicas, name)
+	}
+
+	steps := []struct {
+		name string
+		// content of the configuration file, which is removed if empty
+		content   string
+		wantErr   bool
+		wantNames []string
+	}{
+		{
+			name:      "valid",
+			content:   "services:\n" + service("api", 3) + service("portainer", 2),
+			wantNames: []string{"api", "portainer"},
+		},
+		{
+			name:      "invalid",
+			content:   "services:\n" + service("api", -1),
+			wantErr:   true,
+			wantNames: []string{"api", "portainer"},
+		},
+		{name: "unparsable", content: "services: [", wantErr: true, wantNames: []string{"api", "portainer"}},
+		{name: "missing", wantErr: true, wantNames: []string{"api", "portainer"}},
+		{name: "valid again", content: "services:\n" + service("web", 3), wantNames: []string{"web"}},
+	}
+
+	for _, step := range steps {
+		if step.content == "" {
+			err = os.Remove(path)
+		} else {
+			err = ioutil.WriteFile(path, []byte(step.content), 0644)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := UpdateConfig(path); (err != nil) != step.wantErr {
+			t.Errorf("%s: got error %v, want error %t", step.name, err, step.wantErr)
+		}
+
+		names := []string{}
+		for _, s := range GetConfig().Services {
+			names = append(names, s.Name)
+		}
+
+		if !reflect.DeepEqual(names, step.wantNames) {
+			t.Errorf("%s: got active services %v, want %v", step.name, names, step.wantNames)
+		}
+	}
+}
+
+func TestCPUModeLimitWithoutLimit(t *testing.T) {
+	clusterState := autoscalerTypes.NewClusterState()
+
+	tests := []struct {
+		name          string
+		cpuMode       string
+		nanoCPUsLimit int64
+		wantWarnings  int
+	}{
+		{name: "host", cpuMode: autoscalerTypes.CPUModeHost},
+		{name: "limit", cpuMode: autoscalerTypes.CPUModeLimit, nanoCPUsLimit: 5e8},
+		{name: "limit without a cpu limit", cpuMode: autoscalerTypes.CPUModeLimit, wantWarnings: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusterState.Services["service-1"] = autoscalerTypes.Service{
+				ID:            "service-1",
+				Name:          "api",
+				NanoCPUsLimit: tt.nanoCPUsLimit,
+			}
+
+			serviceConfig := replicasConfig(1, 2, "1m")
+			serviceConfig.CPUMode = tt.cpuMode
+
+			serviceState := getServiceState(context.Background(), clusterState, serviceConfig)
+
+			if len(serviceState.Warnings) != tt.wantWarnings {
+				t.Errorf("got warnings %v, want %d", serviceState.Warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestSamplesRecordedOnce(t *testing.T) {
+	f := &fixture{swarm: fakeswarm.New(), nodes: map[string]string{}}
+	f.nodes["worker-1"] = f.swarm.AddNode("worker-1", swarm.NodeRoleWorker, nil)
+
+	replicas := uint64(1)
+	f.serviceID = f.swarm.AddService(swarm.ServiceSpec{
+		Annotations: swarm.Annotations{Name: "api"},
+		Mode:        swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+	})
+	f.swarm.SetServiceUsage(f.serviceID, overloaded)
+
+	ctx := context.Background()
+
+	now := time.Unix(1500000000, 0)
+	clock = func() time.Time { return now }
+	defer func() { clock = time.Now }()
+
+	serviceConfig := replicasConfig(1, 4, "1m")
+
+	cluster.SetClient(client.New(f.swarm), 4)
+	cluster.SetClock(clock)
+	servicesConfig.Store(autoscalerTypes.ServicesConfig{Services: []autoscalerTypes.ServiceConfig{serviceConfig}})
+	metrics.Reset()
+	scaling = autoscalerTypes.NewScalingState()
+
+	if err := cluster.UpdateState(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	CollectStats(ctx)
+
+	// evaluating more often than collecting, and reporting the status, reuse the sample already recorded
+	for i := 0; i < 3; i++ {
+		now = now.Add(10 * time.Second)
+		(&evaluation{scaling: scaling}).scaleService(ctx, cluster.GetState(), serviceConfig)
+		GetServiceStates(ctx)
+	}
+
+	for _, task := range f.swarm.RunningTasks(f.serviceID) {
+		containerID := task.Status.ContainerStatus.ContainerID
+
+		if window := metrics.Aggregate(containerID, time.Minute, now); window.Samples != 1 {
+			t.Errorf("got %d samples for container %s, want 1", window.Samples, containerID)
+		}
+	}
+}
+
+func TestDryRunServices(t *testing.T) {
+	tests := []struct {
+		name      string
+		replicas  uint64
+		usage     fakeswarm.Usage
+		config    autoscalerTypes.ServiceConfig
+		lastScale time.Duration
+		want      []ScalingDecision
+	}{
+		{
+			name:     "scale out period not over",
+			replicas: 2,
+			usage:    overloaded,
+			config:   replicasConfig(2, 8, "1m"),
+			want:     []ScalingDecision{{Direction: ScaleOut, From: 2, To: 4}},
+		},
+		{
+			name:      "scale in period and cooldown not over",
+			replicas:  4,
+			usage:     idle,
+			config:    withCooldowns(replicasConfig(1, 8, "5m"), "0s", "10m"),
+			lastScale: time.Minute,
+			want:      []ScalingDecision{{Direction: ScaleIn, From: 4, To: 1}},
+		},
+		{
+			name:     "no scaling needed",
+			replicas: 2,
+			usage:    idle,
+			config:   replicasConfig(2, 8, "1m"),
+			want:     []ScalingDecision{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fixture{swarm: fakeswarm.New(), nodes: map[string]string{}}
+			f.nodes["worker-1"] = f.swarm.AddNode("worker-1", swarm.NodeRoleWorker, nil)
+
+			replicas := tt.replicas
+			f.serviceID = f.swarm.AddService(swarm.ServiceSpec{
+				Annotations: swarm.Annotations{Name: tt.config.Name},
+				Mode:        swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+			})
+			f.swarm.SetServiceUsage(f.serviceID, tt.usage)
+
+			ctx := context.Background()
+
+			now := time.Unix(1500000000, 0)
+			clock = func() time.Time { return now }
+			defer func() { clock = time.Now }()
+
+			cluster.SetClient(client.New(f.swarm), 4)
+			cluster.SetClock(clock)
+			servicesConfig.Store(autoscalerTypes.ServicesConfig{Services: []autoscalerTypes.ServiceConfig{tt.config}})
+			metrics.Reset()
+			scaling = autoscalerTypes.NewScalingState()
+
+			if tt.lastScale > 0 {
+				scaling.History[f.serviceID] = autoscalerTypes.ServiceScalingHistory{
+					ServiceID:    f.serviceID,
+					LastScaleOut: now.Add(-tt.lastScale).Unix(),
+				}
+			}
+
+			before := scaling.Copy()
+
+			if err := cluster.UpdateState(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			CollectStats(ctx)
+
+			got := []ScalingDecision{}
+			for _, d := range DryRunServices(ctx) {
+				got = append(got, ScalingDecision{Direction: d.Direction, From: d.From, To: d.To})
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got decisions %+v, want %+v", got, tt.want)
+			}
+
+			if !reflect.DeepEqual(scaling, before) {
+				t.Errorf("got scaling state %+v after the dry run, want %+v", scaling, before)
+			}
+
+			svc, _ := f.swarm.Service(f.serviceID)
+
+			if replicas := *svc.Spec.Mode.Replicated.Replicas; replicas != tt.replicas {
+				t.Errorf("got %d replicas after the dry run, want %d", replicas, tt.replicas)
+			}
+		})
+	}
+}
+
+func TestInterruptedScaleIn(t *testing.T) {
+	f := &fixture{swarm: fakeswarm.New(), nodes: map[string]string{}}
+
+	for _, hostname := range []string{"worker-1", "worker-2", "worker-3", "worker-4"} {
+		f.nodes[hostname] = f.swarm.AddNode(hostname, swarm.NodeRoleWorker, map[string]string{"portainer": "1"})
+	}
+
+	f.serviceID = f.swarm.AddService(swarm.ServiceSpec{
+		Annotations: swarm.Annotations{Name: "portainer"},
+		TaskTemplate: swarm.TaskSpec{
+			Placement: &swarm.Placement{Constraints: []string{"node.labels.portainer == 1"}},
+		},
+		Mode: swarm.ServiceMode{Global: &swarm.GlobalService{}},
+	})
+	f.swarm.SetServiceUsage(f.serviceID, idle)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the shutdown timeout expires while worker-2 is being unlabeled
+	f.swarm.SetNodeUpdateHook(func(ctx context.Context, nodeID string) error {
+		if nodeID == f.nodes["worker-2"] {
+			cancel()
+
+			return ctx.Err()
+		}
+
+		return nil
+	})
+
+	serviceConfig := nodeLabelConfig(1, 4, "0s")
+
+	cluster.SetClient(client.New(f.swarm), 4)
+	cluster.SetClock(clock)
+	servicesConfig.Store(autoscalerTypes.ServicesConfig{Services: []autoscalerTypes.ServiceConfig{serviceConfig}})
+	metrics.Reset()
+	scaling = autoscalerTypes.NewScalingState()
+
+	if err := cluster.UpdateState(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	CollectStats(ctx)
+
+	decision := (&evaluation{scaling: scaling}).scaleService(ctx, cluster.GetState(), serviceConfig)
+
+	if decision == nil || decision.Direction != ScaleIn {
+		t.Fatalf("got decision %+v, want a scale in", decision)
+	}
+
+	err := decision.Scaler.ScaleTo(ctx, decision.To)
+	want := fmt.Sprintf("abandoned unlabeling nodes [%s %s]: context canceled", f.nodes["worker-2"], f.nodes["worker-3"])
+
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+
+	if labeled := f.labeledNodes(t, "portainer"); !reflect.DeepEqual(labeled, []string{"worker-2", "worker-3", "worker-4"}) {
+		t.Errorf("got labeled nodes %v, want the ones left to unlabel", labeled)
+	}
+}
+
+// stubScaler is a Scaler whose ScaleTo calls before and returns err
+type stubScaler struct {
+	before func()
+	err    error
+}
+
+// CurrentCapacity
+func (s *stubScaler) CurrentCapacity() int {
+	return 1
+}
+
+// ScaleTo
+func (s *stubScaler) ScaleTo(ctx context.Context, instances int) error {
+	if s.before != nil {
+		s.before()
+	}
+
+	return s.err
+}
+
+// Describe
+func (s *stubScaler) Describe() string {
+	return "stub"
+}
+
+func TestApplyDecisions(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	clock = func() time.Time { return now }
+	defer func() { clock = time.Now }()
+
+	scaling = autoscalerTypes.NewScalingState()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	decision := func(serviceID string, s *stubScaler) ScalingDecision {
+		return ScalingDecision{
+			ServiceConfig: autoscalerTypes.ServiceConfig{Name: serviceID},
+			ServiceID:     serviceID,
+			Direction:     ScaleOut,
+			From:          1,
+			To:            2,
+			Scaler:        s,
+		}
+	}
+
+	decisions := []ScalingDecision{
+		decision("scaled", &stubScaler{}),
+		decision("failing", &stubScaler{err: fmt.Errorf("cannot label node-1")}),
+		// the shutdown timeout expires while this one is in progress
+		decision("interrupted", &stubScaler{before: cancel, err: context.Canceled}),
+		decision("not started", &stubScaler{}),
+	}
+
+	abandoned := ApplyDecisions(ctx, decisions)
+
+	abandonedIDs := []string{}
+	for _, d := range abandoned {
+		abandonedIDs = append(abandonedIDs, d.ServiceID)
+	}
+
+	if want := []string{"interrupted", "not started"}; !reflect.DeepEqual(abandonedIDs, want) {
+		t.Errorf("got abandoned decisions %v, want %v", abandonedIDs, want)
+	}
+
+	recorded := []string{}
+	for id := range scaling.History {
+		recorded = append(recorded, id)
+	}
+
+	if want := []string{"scaled"}; !reflect.DeepEqual(recorded, want) {
+		t.Errorf("got recorded scalings %v, want %v", recorded, want)
+	}
+
+	if last := scaling.History["scaled"].LastScaleOut; last != now.Unix() {
+		t.Errorf("got last scale out at %d, want %d", last, now.Unix())
+	}
+}
+
+// runScalingRounds evaluates and scales the service of a fixture once per round, checking the outcome of every round
+func runScalingRounds(t *testing.T, f *fixture, serviceConfig autoscalerTypes.ServiceConfig, rounds []scalingRound) {
+	ctx := context.Background()
+
+	now := time.Unix(1500000000, 0)
+	clock = func() time.Time { return now }
+	defer func() { clock = time.Now }()
+
+	cluster.SetClient(client.New(f.swarm), 4)
+	cluster.SetClock(clock)
+	servicesConfig.Store(autoscalerTypes.ServicesConfig{Services: []autoscalerTypes.ServiceConfig{serviceConfig}})
+	metrics.Reset()
+	metrics.SetRetention(time.Hour)
+	scaling = autoscalerTypes.NewScalingState()
+
+	for i, r := range rounds {
+		now = now.Add(r.elapsed)
+
+		if r.prepare != nil {
+			r.prepare(f)
+		}
+
+		if err := cluster.UpdateState(ctx); err != nil {
+			t.Fatalf("round %d: cannot update the cluster state: %s", i, err)
+		}
+
+		CollectStats(ctx)
+
+		var direction string
+
+		// the scaling state is swapped when a round simulates a restart
+		e := &evaluation{scaling: scaling}
+
+		if decision := e.scaleService(ctx, cluster.GetState(), serviceConfig); decision != nil {
+			direction = decision.Direction
+
+			if abandoned := ApplyDecisions(ctx, []ScalingDecision{*decision}); len(abandoned) > 0 {
+				t.Errorf("round %d: got abandoned decisions %v", i, abandoned)
+			}
+		}
+
+		if direction != r.wantDirection {
+			t.Errorf("round %d: got scaling direction %q, want %q", i, direction, r.wantDirection)
+		}
+
+		// ApplyDecisions only records the scalings that succeeded
+		history := scaling.History[f.serviceID]
+		last := history.LastScaleOut
+		if direction == ScaleIn {
+			last = history.LastScaleIn
+		}
+
+		if recorded := last == now.Unix(); direction != "" && recorded == r.wantErr {
+			t.Errorf("round %d: got scaling recorded %t, want %t", i, recorded, !r.wantErr)
+		}
+
+		_, scaleOutStaged := scaling.ScaleOutStaged[f.serviceID]
+		_, scaleInStaged := scaling.ScaleInStaged[f.serviceID]
+
+		if staged := scaleOutStaged || scaleInStaged; staged != r.wantStaged {
+			t.Errorf("round %d: got staged scaling %t, want %t", i, staged, r.wantStaged)
+		}
+
+		if r.wantLabeled != nil {
+			if labeled := f.labeledNodes(t, serviceConfig.NodeLabel); !reflect.DeepEqual(labeled, r.wantLabeled) {
+				t.Errorf("round %d: got labeled nodes %v, want %v", i, labeled, r.wantLabeled)
+			}
+		}
+
+		if r.wantReplicas != 0 {
+			svc, _ := f.swarm.Service(f.serviceID)
+
+			if replicas := *svc.Spec.Mode.Replicated.Replicas; replicas != r.wantReplicas {
+				t.Errorf("round %d: got %d replicas, want %d", i, replicas, r.wantReplicas)
+			}
+
+			if running := len(f.swarm.RunningTasks(f.serviceID)); uint64(running) != r.wantReplicas {
+				t.Errorf("round %d: got %d running tasks, want %d", i, running, r.wantReplicas)
+			}
+		}
+	}
+}
+
+// runReplicasScenarios runs every scenario as a subtest on a new simulated swarm
+func runReplicasScenarios(t *testing.T, scenarios []replicasScenario) {
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			f := &fixture{swarm: fakeswarm.New(), nodes: map[string]string{}}
+
+			for _, hostname := range []string{"worker-1", "worker-2", "worker-3"} {
+				f.nodes[hostname] = f.swarm.AddNode(hostname, swarm.NodeRoleWorker, nil)
+			}
+
+			replicas := s.replicas
+			f.serviceID = f.swarm.AddService(swarm.ServiceSpec{
+				Annotations: swarm.Annotations{Name: s.config.Name},
+				Mode:        swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+			})
+			f.swarm.SetServiceUsage(f.serviceID, s.usage)
+
+			runScalingRounds(t, f, s.config, s.rounds)
+		})
+	}
+}
+
+// setNodeUsage sets the usage of the task the service of the fixture runs on the node with hostname
+func (f *fixture) setNodeUsage(hostname string, usage fakeswarm.Usage) {
+	for _, task := range f.swarm.RunningTasks(f.serviceID) {
+		if task.NodeID == f.nodes[hostname] {
+			f.swarm.SetTaskUsage(task.ID, usage)
+		}
+	}
+}
+
+// setNodeStatsFailure makes fetching the stats of the task the service of the fixture runs on the node with hostname
+// fail with err, or succeed again if err is nil
+func (f *fixture) setNodeStatsFailure(hostname string, err error) {
+	for _, task := range f.swarm.RunningTasks(f.serviceID) {
+		if task.NodeID == f.nodes[hostname] {
+			f.swarm.SetTaskStatsFailure(task.ID, err)
+		}
+	}
+}
+
+// labeledNodes returns the sorted hostnames of the nodes carrying label
+func (f *fixture) labeledNodes(t *testing.T, label string) []string {
+	nodes, err := f.swarm.NodeList(context.Background(), types.NodeListOptions{})
+
+	if err != nil {
+		t.Fatalf("cannot list nodes: %s", err)
+	}
+
+	hostnames := []string{}
+	for _, n := range nodes {
+		if _, ok := n.Spec.Labels[label]; ok {
+			hostnames = append(hostnames, n.Description.Hostname)
+		}
+	}
+
+	sort.Strings(hostnames)
+
+	return hostnames
+}
+
+// nodeLabelConfig
+func nodeLabelConfig(minReplicas int, maxReplicas int, period string) autoscalerTypes.ServiceConfig {
+	return autoscalerTypes.ServiceConfig{
+		Name:        "portainer",
+		MinReplicas: minReplicas,
+		MaxReplicas: maxReplicas,
+		NodeLabel:   "portainer",
+		ScaleOut:    autoscalerTypes.ServiceScaleConditions{CPU: 50, Memory: 50, Period: period},
+		ScaleIn:     autoscalerTypes.ServiceScaleConditions{CPU: 10, Memory: 25, Period: period},
+	}
+}
+
+// replicasConfig
+func replicasConfig(minReplicas int, maxReplicas int, period string) autoscalerTypes.ServiceConfig {
+	return autoscalerTypes.ServiceConfig{
+		Name:        "api",
+		MinReplicas: minReplicas,
+		MaxReplicas: maxReplicas,
+		ScalingMode: autoscalerTypes.ScalingModeReplicas,
+		ScaleOut:    autoscalerTypes.ServiceScaleConditions{CPU: 50, Memory: 50, Period: period},
+		ScaleIn:     autoscalerTypes.ServiceScaleConditions{CPU: 10, Memory: 25, Period: period},
+	}
+}
+
+// targetTrackingConfig
+func targetTrackingConfig(minReplicas int, maxReplicas int, cpu float64, memory float64,
+	period string) autoscalerTypes.ServiceConfig {
+	serviceConfig := replicasConfig(minReplicas, maxReplicas, period)
+	serviceConfig.Policy = autoscalerTypes.PolicyTargetTracking
+	serviceConfig.TargetTracking = autoscalerTypes.TargetTrackingConfig{CPU: cpu, Memory: memory}
+
+	return serviceConfig
+}
+
+// instance has the same usage over both periods, aggregated from samples samples
+func instance(cpu float64, samples int) autoscalerTypes.RunningServiceInstance {
+	usage := autoscalerTypes.ContainerResourceUsage{CPU: cpu, Memory: 10}
+
+	return autoscalerTypes.RunningServiceInstance{
+		ScaleOutUsage:   usage,
+		ScaleOutSamples: samples,
+		ScaleInUsage:    usage,
+		ScaleInSamples:  samples,
+	}
+}
+
+// stepConfig adds 1 instance above 60% cpu, 3 above 80% and removes 1 below 20%
+func stepConfig(minReplicas int, maxReplicas int, period string) autoscalerTypes.ServiceConfig {
+	serviceConfig := replicasConfig(minReplicas, maxReplicas, period)
+	serviceConfig.Policy = autoscalerTypes.PolicyStep
+	serviceConfig.ScaleOut.Steps = []autoscalerTypes.ScalingStep{{CPU: 60, Change: 1}, {CPU: 80, Change: 3}}
+	serviceConfig.ScaleIn.Steps = []autoscalerTypes.ScalingStep{{CPU: 20, Change: 1}}
+
+	return serviceConfig
+}
+
+// withSteps replaces the scale out and scale in steps of a service configuration
+func withSteps(serviceConfig autoscalerTypes.ServiceConfig, scaleOut []autoscalerTypes.ScalingStep,
+	scaleIn []autoscalerTypes.ScalingStep) autoscalerTypes.ServiceConfig {
+	serviceConfig.ScaleOut.Steps = scaleOut
+	serviceConfig.ScaleIn.Steps = scaleIn
+
+	return serviceConfig
+}
+
+// withCooldowns sets the scale out and scale in cooldowns of a service configuration
+func withCooldowns(serviceConfig autoscalerTypes.ServiceConfig, scaleOut string,
+	scaleIn string) autoscalerTypes.ServiceConfig {
+	serviceConfig.ScaleOut.Cooldown = scaleOut
+	serviceConfig.ScaleIn.Cooldown = scaleIn
+
+	return serviceConfig
+}
+
+// withScaleInStrategy sets the scale in strategy of a service configuration
+func withScaleInStrategy(serviceConfig autoscalerTypes.ServiceConfig, strategy string) autoscalerTypes.ServiceConfig {
+	serviceConfig.ScaleInStrategy = strategy
+
+	return serviceConfig
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"../scaler"
+	"../types"
+)
+
+// scaleServiceBySteps decides the scaling of a service from the largest step its average usage crosses, the scale out
+// steps being checked first
+//
+// Like with the other policies, a scaling must be needed for the whole period of its direction before it is due. The
+// instances without samples over a period are left out of its average instead of counting as idle, and no scaling is
+// decided in a direction while none of them has samples
+func (e *evaluation) scaleServiceBySteps(serviceConfig types.ServiceConfig, serviceState types.ServiceState,
+	serviceScaler scaler.Scaler, capacity int) *ScalingDecision {
+	serviceID := serviceState.Service.ID
+	instances := serviceState.RunningServiceInstances
+
+	if len(instances) == 0 {
+		log.Debugf("No usage reported by the instances of service %s yet", serviceConfig.Name)
+
+		delete(e.scaling.ScaleOutStaged, serviceID)
+		delete(e.scaling.ScaleInStaged, serviceID)
+
+		return nil
+	}
+
+	scaleOutInstances := getSampledInstances(instances,
+		func(r types.RunningServiceInstance) int { return r.ScaleOutSamples })
+
+	if len(scaleOutInstances) > 0 {
+		scaleOutUsage := getAverageUsage(scaleOutInstances,
+			func(r types.RunningServiceInstance) types.ContainerResourceUsage { return r.ScaleOutUsage })
+
+		if step, ok := getLargestStep(serviceConfig.ScaleOut.Steps, scaleOutUsage, isAboveStep); ok &&
+			capacity < serviceConfig.MaxReplicas {
+			delete(e.scaling.ScaleInStaged, serviceID)
+
+			if !e.stageScaling(e.scaling.ScaleOutStaged, serviceID, serviceConfig.ScaleOut.Period) {
+				return nil
+			}
+
+			delete(e.scaling.ScaleOutStaged, serviceID)
+
+			target := capacity + step.Change
+			if target > serviceConfig.MaxReplicas {
+				target = serviceConfig.MaxReplicas
+			}
+
+			return newScalingDecision(serviceConfig, serviceScaler, capacity, target,
+				fmt.Sprintf("average usage %s is above the %s step", describeUsage(scaleOutUsage), describeStep(step)))
+		}
+	}
+
+	delete(e.scaling.ScaleOutStaged, serviceID)
+
+	scaleInInstances := getSampledInstances(instances,
+		func(r types.RunningServiceInstance) int { return r.ScaleInSamples })
+
+	if len(scaleInInstances) == 0 {
+		log.Debugf("No instance of service %s has samples over its scale in period yet", serviceConfig.Name)
+
+		delete(e.scaling.ScaleInStaged, serviceID)
+
+		return nil
+	}
+
+	scaleInUsage := getAverageUsage(scaleInInstances,
+		func(r types.RunningServiceInstance) types.ContainerResourceUsage { return r.ScaleInUsage })
+
+	step, ok := getLargestStep(serviceConfig.ScaleIn.Steps, scaleInUsage, isBelowStep)
+
+	if !ok || capacity <= serviceConfig.MinReplicas {
+		delete(e.scaling.ScaleInStaged, serviceID)
+
+		return nil
+	}
+
+	if !e.stageScaling(e.scaling.ScaleInStaged, serviceID, serviceConfig.ScaleIn.Period) {
+		return nil
+	}
+
+	delete(e.scaling.ScaleInStaged, serviceID)
+
+	target := capacity - step.Change
+	if target < serviceConfig.MinReplicas {
+		target = serviceConfig.MinReplicas
+	}
+
+	return newScalingDecision(serviceConfig, serviceScaler, capacity, target,
+		fmt.Sprintf("average usage %s is below the %s step", describeUsage(scaleInUsage), describeStep(step)))
+}
+
+// getLargestStep returns the step with the largest change among the steps crossed by usage
+func getLargestStep(steps []types.ScalingStep, usage types.ContainerResourceUsage,
+	crossed func(types.ScalingStep, types.ContainerResourceUsage) bool) (largest types.ScalingStep, ok bool) {
+	for _, s := range steps {
+		if crossed(s, usage) && (!ok || s.Change > largest.Change) {
+			largest = s
+			ok = true
+		}
+	}
+
+	return largest, ok
+}
+
+// isAboveStep reports whether usage is above any threshold of a scale out step
+func isAboveStep(step types.ScalingStep, usage types.ContainerResourceUsage) bool {
+	return (step.CPU > 0 && usage.CPU > step.CPU) || (step.Memory > 0 && usage.Memory > step.Memory)
+}
+
+// isBelowStep reports whether usage is below every threshold of a scale in step
+func isBelowStep(step types.ScalingStep, usage types.ContainerResourceUsage) bool {
+	return (step.CPU == 0 || usage.CPU < step.CPU) && (step.Memory == 0 || usage.Memory < step.Memory)
+}
+
+// describeUsage
+func describeUsage(usage types.ContainerResourceUsage) string {
+	return fmt.Sprintf("cpu %.1f%% memory %.1f%%", usage.CPU, usage.Memory)
+}
+
+// describeStep
+func describeStep(step types.ScalingStep) string {
+	thresholds := ""
+
+	if step.CPU > 0 {
+		thresholds += fmt.Sprintf("cpu %.1f%% ", step.CPU)
+	}
+
+	if step.Memory > 0 {
+		thresholds += fmt.Sprintf("memory %.1f%% ", step.Memory)
+	}
+
+	return fmt.Sprintf("%s(%d instances)", thresholds, step.Change)
+}
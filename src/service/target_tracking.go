@@ -0,0 +1,155 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"../scaler"
+	"../types"
+)
+
+// scaleServiceByTargetTracking decides the scaling of a service that keeps the average usage of its instances at the
+// targets of its configuration
+//
+// A scale out is computed from the usage aggregated over the scale out period and must be needed for that whole period
+// before it is due, and likewise for a scale in. Like the Kubernetes horizontal pod autoscaler does with the pods that
+// are not ready, the instances without samples over a period, which just started or stopped reporting, are left out
+// of the average instead of counting as idle, and no scale in is decided while none of them has samples
+func (e *evaluation) scaleServiceByTargetTracking(serviceConfig types.ServiceConfig, serviceState types.ServiceState,
+	serviceScaler scaler.Scaler, capacity int) *ScalingDecision {
+	serviceID := serviceState.Service.ID
+	instances := serviceState.RunningServiceInstances
+
+	if len(instances) == 0 {
+		log.Debugf("No usage reported by the instances of service %s yet", serviceConfig.Name)
+
+		delete(e.scaling.ScaleOutStaged, serviceID)
+		delete(e.scaling.ScaleInStaged, serviceID)
+
+		return nil
+	}
+
+	scaleOutInstances := getSampledInstances(instances,
+		func(r types.RunningServiceInstance) int { return r.ScaleOutSamples })
+
+	if len(scaleOutInstances) > 0 {
+		desired, reason := getTargetTrackingReplicas(serviceConfig, capacity, scaleOutInstances,
+			func(r types.RunningServiceInstance) types.ContainerResourceUsage { return r.ScaleOutUsage })
+
+		if desired > capacity {
+			delete(e.scaling.ScaleInStaged, serviceID)
+
+			if !e.stageScaling(e.scaling.ScaleOutStaged, serviceID, serviceConfig.ScaleOut.Period) {
+				return nil
+			}
+
+			delete(e.scaling.ScaleOutStaged, serviceID)
+
+			return newScalingDecision(serviceConfig, serviceScaler, capacity, desired, reason)
+		}
+	}
+
+	delete(e.scaling.ScaleOutStaged, serviceID)
+
+	scaleInInstances := getSampledInstances(instances,
+		func(r types.RunningServiceInstance) int { return r.ScaleInSamples })
+
+	if len(scaleInInstances) == 0 {
+		log.Debugf("No instance of service %s has samples over its scale in period yet", serviceConfig.Name)
+
+		delete(e.scaling.ScaleInStaged, serviceID)
+
+		return nil
+	}
+
+	desired, reason := getTargetTrackingReplicas(serviceConfig, capacity, scaleInInstances,
+		func(r types.RunningServiceInstance) types.ContainerResourceUsage { return r.ScaleInUsage })
+
+	if desired >= capacity {
+		delete(e.scaling.ScaleInStaged, serviceID)
+
+		return nil
+	}
+
+	if !e.stageScaling(e.scaling.ScaleInStaged, serviceID, serviceConfig.ScaleIn.Period) {
+		return nil
+	}
+
+	delete(e.scaling.ScaleInStaged, serviceID)
+
+	return newScalingDecision(serviceConfig, serviceScaler, capacity, desired, reason)
+}
+
+// getSampledInstances returns the instances of a service that have samples over a period
+func getSampledInstances(instances []types.RunningServiceInstance,
+	samples func(types.RunningServiceInstance) int) []types.RunningServiceInstance {
+	result := []types.RunningServiceInstance{}
+
+	for _, r := range instances {
+		if samples(r) > 0 {
+			result = append(result, r)
+		}
+	}
+
+	return result
+}
+
+// getTargetTrackingReplicas computes the number of instances that brings the average usage of the instances of a
+// service to its targets, like the Kubernetes horizontal pod autoscaler: ceil(capacity * average / target) for every
+// tracked resource, keeping the largest and clamping it to the min and max replicas of the service
+//
+// A resource whose average usage is within the tolerance of its target asks for the current capacity
+func getTargetTrackingReplicas(serviceConfig types.ServiceConfig, capacity int,
+	instances []types.RunningServiceInstance, usage func(types.RunningServiceInstance) types.ContainerResourceUsage) (
+	desired int, reason string) {
+	average := getAverageUsage(instances, usage)
+	targetTracking := serviceConfig.TargetTracking
+	tolerance := targetTracking.GetTolerance()
+	reasons := []string{}
+	desired = 0
+
+	for _, m := range []struct {
+		name    string
+		average float64
+		target  float64
+	}{
+		{"cpu", average.CPU, targetTracking.CPU},
+		{"memory", average.Memory, targetTracking.Memory},
+	} {
+		if m.target <= 0 {
+			continue
+		}
+
+		ratio := m.average / m.target
+		replicas := capacity
+
+		if math.Abs(ratio-1.0) > tolerance {
+			replicas = int(math.Ceil(float64(capacity) * ratio))
+		}
+
+		if replicas > desired {
+			desired = replicas
+		}
+
+		reasons = append(reasons, fmt.Sprintf("average %s usage is %.1f%% for a %.1f%% target", m.name, m.average,
+			m.target))
+	}
+
+	if desired < serviceConfig.MinReplicas {
+		desired = serviceConfig.MinReplicas
+	}
+
+	if desired > serviceConfig.MaxReplicas {
+		log.Debugf("%d instances needed to meet the targets of service %s but only %d instances can be used",
+			desired,
+			serviceConfig.Name,
+			serviceConfig.MaxReplicas)
+
+		desired = serviceConfig.MaxReplicas
+	}
+
+	return desired, strings.Join(reasons, ", ")
+}
@@ -0,0 +1,78 @@
+package statestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"../types"
+)
+
+// FileStore keeps the scaling state in a json file
+type FileStore struct {
+	path string
+}
+
+// NewFileStore creates a FileStore keeping the state in the file at path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load reads the state file, a missing file holding an empty state
+func (s *FileStore) Load(ctx context.Context) (types.ScalingState, error) {
+	result := types.NewScalingState()
+
+	data, err := ioutil.ReadFile(s.path)
+
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+
+	if err != nil {
+		return result, err
+	}
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return types.NewScalingState(), fmt.Errorf("invalid state file %s: %s", s.path, err)
+	}
+
+	// the maps missing from the file are decoded as nil
+	return result.Copy(), nil
+}
+
+// Save writes the state file through a temporary file renamed over it, so that a crash never leaves it half written
+func (s *FileStore) Save(ctx context.Context, state types.ScalingState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Describe
+func (s *FileStore) Describe() string {
+	return fmt.Sprintf("file %s", s.path)
+}
@@ -0,0 +1,171 @@
+package statestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"../cluster"
+	"../types"
+)
+
+// StateLabel is the label of a service holding its scaling state
+const StateLabel = "docker-service-autoscaler.state"
+
+// serviceState is the part of the scaling state kept in the label of a single service
+type serviceState struct {
+	ScaleOutStaged *types.ServiceStagedScaling  `json:"scale_out_staged,omitempty"`
+	ScaleInStaged  *types.ServiceStagedScaling  `json:"scale_in_staged,omitempty"`
+	History        *types.ServiceScalingHistory `json:"history,omitempty"`
+}
+
+// ServiceLabelStore keeps the scaling state of every service in a label of the service itself, so that it lives in
+// the swarm rather than on the node the autoscaler runs on
+//
+// The labels are read from the cluster state, which must have been refreshed before Load
+type ServiceLabelStore struct {
+	// labels are the last label values loaded or saved, by service ID, to only update the services whose state changed
+	labels map[string]string
+}
+
+// NewServiceLabelStore
+func NewServiceLabelStore() *ServiceLabelStore {
+	return &ServiceLabelStore{labels: map[string]string{}}
+}
+
+// Load reads the state label of every service of the cluster state
+func (s *ServiceLabelStore) Load(ctx context.Context) (types.ScalingState, error) {
+	result := types.NewScalingState()
+	s.labels = map[string]string{}
+
+	for id, svc := range cluster.GetState().Services {
+		value, ok := svc.Labels[StateLabel]
+
+		if !ok {
+			continue
+		}
+
+		s.labels[id] = value
+
+		var state serviceState
+
+		if err := json.Unmarshal([]byte(value), &state); err != nil {
+			log.Warnf("ignoring the invalid %s label of service %s: %s", StateLabel, svc.Name, err)
+
+			continue
+		}
+
+		if state.ScaleOutStaged != nil {
+			result.ScaleOutStaged[id] = *state.ScaleOutStaged
+		}
+
+		if state.ScaleInStaged != nil {
+			result.ScaleInStaged[id] = *state.ScaleInStaged
+		}
+
+		if state.History != nil {
+			result.History[id] = *state.History
+		}
+	}
+
+	return result, nil
+}
+
+// Save updates the state label of the services whose state changed since the last Load or Save, removing it from the
+// services that no longer have any state
+func (s *ServiceLabelStore) Save(ctx context.Context, state types.ScalingState) error {
+	labels := map[string]string{}
+
+	for _, id := range serviceIDs(state) {
+		var svcState serviceState
+
+		if staged, ok := state.ScaleOutStaged[id]; ok {
+			svcState.ScaleOutStaged = &staged
+		}
+
+		if staged, ok := state.ScaleInStaged[id]; ok {
+			svcState.ScaleInStaged = &staged
+		}
+
+		if history, ok := state.History[id]; ok {
+			svcState.History = &history
+		}
+
+		data, err := json.Marshal(svcState)
+
+		if err != nil {
+			return err
+		}
+
+		labels[id] = string(data)
+	}
+
+	for id := range s.labels {
+		if _, ok := labels[id]; !ok {
+			labels[id] = ""
+		}
+	}
+
+	failed := []string{}
+
+	for id, value := range labels {
+		if s.labels[id] == value {
+			continue
+		}
+
+		if err := cluster.SetServiceLabel(ctx, id, StateLabel, value); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", id, err))
+
+			continue
+		}
+
+		if value == "" {
+			delete(s.labels, id)
+		} else {
+			s.labels[id] = value
+		}
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+
+		return fmt.Errorf("cannot update the %s label of services %s", StateLabel, strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// Describe
+func (s *ServiceLabelStore) Describe() string {
+	return fmt.Sprintf("service label %s", StateLabel)
+}
+
+// serviceIDs lists the IDs of the services with any state
+func serviceIDs(state types.ScalingState) []string {
+	ids := map[string]bool{}
+
+	for id := range state.ScaleOutStaged {
+		ids[id] = true
+	}
+
+	for id := range state.ScaleInStaged {
+		ids[id] = true
+	}
+
+	for id := range state.History {
+		ids[id] = true
+	}
+
+	result := make([]string, 0, len(ids))
+	for id := range ids {
+		result = append(result, id)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
@@ -0,0 +1,36 @@
+package statestore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"../types"
+)
+
+const (
+	// fileScheme prefixes the path of the file a FileStore keeps the state in
+	fileScheme = "file:"
+	// serviceLabelSpec selects the ServiceLabelStore
+	serviceLabelSpec = "service-label"
+)
+
+// Store keeps the scaling state of the autoscaler so that a restart does not lose the staged scalings and cooldowns
+type Store interface {
+	// Load returns the saved state, which is empty if none was saved yet
+	Load(ctx context.Context) (types.ScalingState, error)
+	Save(ctx context.Context, state types.ScalingState) error
+	Describe() string
+}
+
+// New creates the Store described by spec, either file:<path> or service-label
+func New(spec string) (Store, error) {
+	switch {
+	case strings.HasPrefix(spec, fileScheme) && len(spec) > len(fileScheme):
+		return NewFileStore(strings.TrimPrefix(spec, fileScheme)), nil
+	case spec == serviceLabelSpec:
+		return NewServiceLabelStore(), nil
+	}
+
+	return nil, fmt.Errorf("invalid state store %q, must be %s<path> or %s", spec, fileScheme, serviceLabelSpec)
+}
@@ -0,0 +1,189 @@
+package statestore
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+
+	"../client"
+	"../cluster"
+	"../fakeswarm"
+	"../types"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Store
+		wantErr bool
+	}{
+		{spec: "file:/var/lib/autoscaler/state.json", want: NewFileStore("/var/lib/autoscaler/state.json")},
+		{spec: "service-label", want: NewServiceLabelStore()},
+		{spec: "file:", wantErr: true},
+		{spec: "/var/lib/autoscaler/state.json", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := New(tt.spec)
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("New(%q) error = %v, want error %t", tt.spec, err, tt.wantErr)
+
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("New(%q) = %#v, want %#v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statestore")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	store := NewFileStore(filepath.Join(dir, "state.json"))
+
+	loaded, err := store.Load(ctx)
+
+	if err != nil {
+		t.Fatalf("Load() of a missing file error = %s", err)
+	}
+
+	if !reflect.DeepEqual(loaded, types.NewScalingState()) {
+		t.Errorf("Load() of a missing file = %+v, want an empty state", loaded)
+	}
+
+	state := testScalingState("service-1", "service-2")
+
+	if err := store.Save(ctx, state); err != nil {
+		t.Fatalf("Save() error = %s", err)
+	}
+
+	if loaded, err = store.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %s", err)
+	}
+
+	if !reflect.DeepEqual(loaded, state) {
+		t.Errorf("Load() = %+v, want %+v", loaded, state)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Save() left %d files, want only the state file", len(files))
+	}
+}
+
+func TestServiceLabelStore(t *testing.T) {
+	ctx := context.Background()
+	f := fakeswarm.New()
+	f.AddNode("worker-1", swarm.NodeRoleWorker, nil)
+	// api uses settings added to docker after the version the vendored api types come from
+	const apiSpec = `{
+		"Name": "api",
+		"TaskTemplate": {
+			"ContainerSpec": {"Image": "api:1.0", "Init": true},
+			"Placement": {"Preferences": [{"Spread": {"SpreadDescriptor": "node.labels.zone"}}]}
+		},
+		"Mode": {"Replicated": {"Replicas": 1}}
+	}`
+	id1 := f.AddRawService(apiSpec)
+	id2 := f.AddService(swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "web"}})
+
+	cluster.SetClient(client.New(f), 1)
+
+	if err := cluster.UpdateState(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewServiceLabelStore()
+	state := testScalingState(id1, id2)
+
+	if err := store.Save(ctx, state); err != nil {
+		t.Fatalf("Save() error = %s", err)
+	}
+
+	// a restarted autoscaler
+	if err := cluster.UpdateState(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewServiceLabelStore().Load(ctx)
+
+	if err != nil {
+		t.Fatalf("Load() error = %s", err)
+	}
+
+	if !reflect.DeepEqual(loaded, state) {
+		t.Errorf("Load() = %+v, want %+v", loaded, state)
+	}
+
+	state.Forget(id2)
+
+	if err := store.Save(ctx, state); err != nil {
+		t.Fatalf("Save() error = %s", err)
+	}
+
+	svc, _ := f.Service(id2)
+
+	if _, ok := svc.Spec.Labels[StateLabel]; ok {
+		t.Errorf("the %s label of a service without state was not removed", StateLabel)
+	}
+
+	svc, _ = f.Service(id1)
+
+	if _, ok := svc.Spec.Labels[StateLabel]; !ok {
+		t.Errorf("the %s label of a service with state was removed", StateLabel)
+	}
+
+	// saving the state changed nothing but the label, so the tasks of the service are not restarted
+	_, raw, err := f.ServiceInspectWithRaw(ctx, id1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got, want struct {
+		Spec map[string]interface{}
+	}
+
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"Spec": `+apiSpec+`}`), &want); err != nil {
+		t.Fatal(err)
+	}
+
+	delete(got.Spec, "Labels")
+
+	if !reflect.DeepEqual(got.Spec, want.Spec) {
+		t.Errorf("got spec %v besides the labels, want %v", got.Spec, want.Spec)
+	}
+}
+
+// testScalingState
+func testScalingState(serviceID1 string, serviceID2 string) types.ScalingState {
+	state := types.NewScalingState()
+	state.ScaleOutStaged[serviceID1] = types.ServiceStagedScaling{ServiceID: serviceID1, StagedTimestamp: 1500000060}
+	state.ScaleInStaged[serviceID2] = types.ServiceStagedScaling{ServiceID: serviceID2, StagedTimestamp: 1500000120}
+	state.History[serviceID1] = types.ServiceScalingHistory{ServiceID: serviceID1, LastScaleOut: 1500000000}
+	state.History[serviceID2] = types.ServiceScalingHistory{
+		ServiceID:    serviceID2,
+		LastScaleOut: 1500000000,
+		LastScaleIn:  1500000030,
+	}
+
+	return state
+}
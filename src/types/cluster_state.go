@@ -1,17 +1,25 @@
-package types
-
-// ClusterState represents all the objects currently in the swarm cluster
-type ClusterState struct {
-	RunningTasks       map[string]RunningTask
-	Services           map[string]Service
-	RunningActiveNodes map[string]Node
-}
-
-// NewClusterState creates a new ClusterState object
-func NewClusterState() ClusterState {
-	return ClusterState{
-		RunningTasks:       map[string]RunningTask{},
-		Services:           map[string]Service{},
-		RunningActiveNodes: map[string]Node{},
-	}
-}
+package types
+
+import "time"
+
+// ClusterState represents all the objects currently in the swarm cluster
+//
+// A ClusterState is an immutable snapshot once it has been published, so its maps must never be modified
+type ClusterState struct {
+	RunningTasks       map[string]RunningTask
+	Services           map[string]Service
+	RunningActiveNodes map[string]Node
+	// Generation is incremented on every refresh of the cluster state
+	Generation uint64
+	// Timestamp is the time when the snapshot was taken
+	Timestamp time.Time
+}
+
+// NewClusterState creates a new ClusterState object
+func NewClusterState() ClusterState {
+	return ClusterState{
+		RunningTasks:       map[string]RunningTask{},
+		Services:           map[string]Service{},
+		RunningActiveNodes: map[string]Node{},
+	}
+}
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 // ContainerStatsRaw is the object that Docker exposes in the stats stream of a container
 type ContainerStatsRaw struct {
 	ID          string `json:"id"`
@@ -25,10 +27,14 @@ type ContainerStatsRaw struct {
 		SystemCPUUsage int64 `json:"system_cpu_usage"`
 	} `json:"cpu_stats"`
 	MemoryStats struct {
+		Usage    int64 `json:"usage"`
 		Limit    int64 `json:"limit"`
 		MaxUsage int64 `json:"max_usage"`
 		Stats    struct {
-			RSS int64 `json:"rss"`
+			RSS               int64 `json:"rss"`
+			Cache             int64 `json:"cache"`
+			TotalInactiveFile int64 `json:"total_inactive_file"` // cgroup v1
+			InactiveFile      int64 `json:"inactive_file"`       // cgroup v2
 		} `json:"stats"`
 	} `json:"memory_stats"`
 }
@@ -43,4 +49,6 @@ type ContainerResourceUsage struct {
 type ContainerStats struct {
 	Raw   ContainerStatsRaw
 	Usage ContainerResourceUsage
+	// Timestamp is when the stats were collected
+	Timestamp time.Time
 }
@@ -0,0 +1,16 @@
+package types
+
+// HostStats represents the resource usage of a whole node as reported by the agent running on it
+type HostStats struct {
+	Hostname string `json:"hostname"`
+	// CPU is the percentage of the time all the cpus of the node were busy since the previous sample
+	CPU        float64 `json:"cpu"`
+	OnlineCPUs int64   `json:"online_cpus"`
+	// Memory is the percentage of the memory of the node that is not available for new processes
+	Memory          float64 `json:"memory"`
+	MemoryTotal     int64   `json:"memory_total"`
+	MemoryAvailable int64   `json:"memory_available"`
+	Load1           float64 `json:"load1"`
+	Load5           float64 `json:"load5"`
+	Load15          float64 `json:"load15"`
+}
@@ -2,9 +2,37 @@ package types
 
 // Node represents a node in the docker swarm cluster
 type Node struct {
-	ID       string
-	IP       string
-	Hostname string
-	Role     string
-	Leader   bool
+	ID            string
+	IP            string
+	Hostname      string
+	Role          string
+	Leader        bool
+	Availability  string
+	State         string
+	Labels        map[string]string
+	EngineLabels  map[string]string
+	EngineVersion string
+	Platform      Platform
+	Resources     NodeResources
+}
+
+// Platform represents the operating system and architecture of a node
+type Platform struct {
+	OS           string
+	Architecture string
+}
+
+// NodeUsage represents the utilisation of a node as percentages of its total resources
+type NodeUsage struct {
+	CPU    float64
+	Memory float64
+	// Estimated is true when the usage is the sum of the usage of the containers collected on the node rather than
+	// the usage of the whole node reported by its agent
+	Estimated bool
+}
+
+// NodeResources represents the total resources of a node, or the resources reserved on one
+type NodeResources struct {
+	NanoCPUs    int64
+	MemoryBytes int64
 }
@@ -1,9 +1,15 @@
 package types
 
+import "time"
+
 // RunningTask represents a task in the docker swarm cluster
 type RunningTask struct {
 	ID          string
 	NodeID      string
 	ServiceID   string
 	ContainerID string
+	// Reservations are the resources the task reserved on its node
+	Reservations NodeResources
+	// CreatedAt is when the task was created
+	CreatedAt time.Time
 }
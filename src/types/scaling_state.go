@@ -0,0 +1,44 @@
+package types
+
+// ScalingState represents the scalings staged for the autoscaled services and when they were last scaled, by service
+// ID, which is what must survive a restart of the autoscaler
+type ScalingState struct {
+	ScaleOutStaged map[string]ServiceStagedScaling  `json:"scale_out_staged"`
+	ScaleInStaged  map[string]ServiceStagedScaling  `json:"scale_in_staged"`
+	History        map[string]ServiceScalingHistory `json:"history"`
+}
+
+// NewScalingState creates a new ScalingState object
+func NewScalingState() ScalingState {
+	return ScalingState{
+		ScaleOutStaged: map[string]ServiceStagedScaling{},
+		ScaleInStaged:  map[string]ServiceStagedScaling{},
+		History:        map[string]ServiceScalingHistory{},
+	}
+}
+
+// Copy returns a ScalingState object that shares no map with s
+func (s ScalingState) Copy() ScalingState {
+	result := NewScalingState()
+
+	for id, staged := range s.ScaleOutStaged {
+		result.ScaleOutStaged[id] = staged
+	}
+
+	for id, staged := range s.ScaleInStaged {
+		result.ScaleInStaged[id] = staged
+	}
+
+	for id, history := range s.History {
+		result.History[id] = history
+	}
+
+	return result
+}
+
+// Forget removes everything known about a service
+func (s ScalingState) Forget(serviceID string) {
+	delete(s.ScaleOutStaged, serviceID)
+	delete(s.ScaleInStaged, serviceID)
+	delete(s.History, serviceID)
+}
@@ -2,25 +2,60 @@ package types
 
 // Service models a docker service
 type Service struct {
-	ID   string
-	Name string
+	ID            string
+	Name          string
+	Labels        map[string]string
+	NanoCPUsLimit int64
+	// Reservations are the resources every task of the service reserves on its node
+	Reservations NodeResources
+	// Constraints are the placement constraint expressions of the service, like node.role == manager
+	Constraints []string
+	// Platforms are the platforms the image of the service runs on, any platform when empty, an empty field of one
+	// matching any operating system or architecture
+	Platforms []Platform
+	// Preferences are the descriptors of the spread placement preferences of the service, like node.labels.zone, in
+	// order of precedence
+	Preferences []string
+	Mode        string
+	// Replicas is the desired number of tasks of a replicated mode service
+	Replicas uint64
 }
 
+const (
+	// ServiceModeReplicated is the mode of a service that runs a given number of tasks
+	ServiceModeReplicated = "replicated"
+	// ServiceModeGlobal is the mode of a service that runs a task on every node matching its constraints
+	ServiceModeGlobal = "global"
+)
+
 // RunningServiceInstance represents a running service instance on a particular node in a swarm cluster with the resources it consumers on the node
 type RunningServiceInstance struct {
 	Node           Node
+	Task           RunningTask
 	ContainerStats ContainerStats
+	// ScaleOutUsage is the usage of the instance aggregated over the ScaleOut period of the service
+	ScaleOutUsage ContainerResourceUsage
+	// ScaleOutSamples is the number of samples ScaleOutUsage is aggregated from, 0 meaning its usage is unknown
+	ScaleOutSamples int
+	// ScaleInUsage is the usage of the instance aggregated over the ScaleIn period of the service
+	ScaleInUsage ContainerResourceUsage
+	// ScaleInSamples is the number of samples ScaleInUsage is aggregated from, 0 meaning its usage is unknown
+	ScaleInSamples int
 }
 
 // ServiceState represents the running state of a service in a swarm cluster
 type ServiceState struct {
 	Service                 Service
 	RunningServiceInstances []RunningServiceInstance
+	ScalingHistory          ServiceScalingHistory
+	// Warnings describe the parts of the configuration of the service that do not apply to it as written
+	Warnings []string
 }
 
 // ServicesConfig represents the deserialized service configuration json passed to the program
 type ServicesConfig struct {
 	Services []ServiceConfig `json:"services"`
+	Stats    StatsConfig     `json:"stats"`
 }
 
 // ServiceConfig represents the configuration section for a single service in the ServicesConfig object
@@ -31,13 +66,118 @@ type ServiceConfig struct {
 	ScaleOut    ServiceScaleConditions `json:"scale_out"`
 	ScaleIn     ServiceScaleConditions `json:"scale_in"`
 	NodeLabel   string                 `json:"node_label"`
+	CPUMode     string                 `json:"cpu_mode"`
+	ScalingMode string                 `json:"scaling_mode"`
+	// Policy decides how many instances the service needs, PolicyThreshold by default
+	Policy         string               `json:"policy"`
+	TargetTracking TargetTrackingConfig `json:"target_tracking"`
+	// ScaleInStrategy picks the instances stopped by a scale in of a node_label service, ScaleInStrategyLeastLoaded by
+	// default
+	ScaleInStrategy string `json:"scale_in_strategy"`
+}
+
+const (
+	// ScaleInStrategyLeastLoaded stops the instances using the least cpu, then memory, over the ScaleIn period
+	ScaleInStrategyLeastLoaded = "least_loaded"
+	// ScaleInStrategyNewest stops the most recently started instances
+	ScaleInStrategyNewest = "newest"
+	// ScaleInStrategyOldest stops the longest running instances
+	ScaleInStrategyOldest = "oldest"
+	// ScaleInStrategyMostOtherLoad stops the instances whose node is the most loaded by everything else
+	ScaleInStrategyMostOtherLoad = "most_other_load"
+)
+
+const (
+	// PolicyThreshold keeps MinReplicas instances below the ScaleOut usage, adding one instance for every instance
+	// above it
+	PolicyThreshold = "threshold"
+	// PolicyTargetTracking sizes the service so that the average usage of its instances meets the TargetTracking targets
+	PolicyTargetTracking = "target_tracking"
+	// PolicyStep adds or removes the number of instances of the largest ScaleOut or ScaleIn step the average usage of
+	// the instances crosses
+	PolicyStep = "step"
+)
+
+// TargetTrackingConfig represents the average usage the target tracking policy keeps the instances of a service at
+type TargetTrackingConfig struct {
+	// CPU and Memory are target percentages, a zero target is not tracked
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+	// Tolerance is how far, as a fraction of the target, the usage can drift from the target before scaling
+	Tolerance *float64 `json:"tolerance"`
+}
+
+// DefaultTargetTrackingTolerance is the tolerance of the target tracking policy when none is configured, the same as
+// the Kubernetes horizontal pod autoscaler
+const DefaultTargetTrackingTolerance = 0.1
+
+// GetTolerance returns the configured tolerance or the default one
+func (c TargetTrackingConfig) GetTolerance() float64 {
+	if c.Tolerance == nil {
+		return DefaultTargetTrackingTolerance
+	}
+
+	return *c.Tolerance
 }
 
+const (
+	// ScalingModeNodeLabel scales a global mode service constrained on NodeLabel by adding the label to or removing it
+	// from nodes
+	ScalingModeNodeLabel = "node_label"
+	// ScalingModeReplicas scales a replicated mode service by updating its replica count
+	ScalingModeReplicas = "replicas"
+)
+
+const (
+	// CPUModeHost expresses the cpu usage of a container relative to a single cpu of its host, like docker stats does
+	CPUModeHost = "host"
+	// CPUModeLimit expresses the cpu usage of a container relative to the cpu limit (NanoCPUs) of its service
+	CPUModeLimit = "limit"
+)
+
 // ServiceScaleConditions represents the resource usage that triggers a scale out/in for a service
 type ServiceScaleConditions struct {
 	CPU    float64 `json:"cpu"`
 	Memory float64 `json:"memory"`
 	Period string  `json:"period"`
+	// Statistic is the aggregation of the usage samples taken over Period that is compared to CPU and Memory
+	Statistic string `json:"statistic"`
+	// Steps are the thresholds of the step policy, which ignores CPU and Memory
+	Steps []ScalingStep `json:"steps"`
+	// Cooldown is how long to wait after a scaling before scaling in this direction again
+	Cooldown string `json:"cooldown"`
+}
+
+// ScalingStep represents a threshold of the step policy and the number of instances to add or remove when the average
+// usage of the instances of a service crosses it, above it for a scale out and below it for a scale in
+type ScalingStep struct {
+	// CPU and Memory are percentages, a zero one is not checked
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+	Change int     `json:"change"`
+}
+
+const (
+	// StatisticAverage aggregates usage samples by their mean, the default
+	StatisticAverage = "avg"
+	// StatisticMax aggregates usage samples by their highest value
+	StatisticMax = "max"
+	// StatisticP50 aggregates usage samples by their median
+	StatisticP50 = "p50"
+	// StatisticP90 aggregates usage samples by their 90th percentile
+	StatisticP90 = "p90"
+	// StatisticP95 aggregates usage samples by their 95th percentile
+	StatisticP95 = "p95"
+	// StatisticP99 aggregates usage samples by their 99th percentile
+	StatisticP99 = "p99"
+)
+
+// ServiceScalingHistory records when a service was last scaled out and in, as unix timestamps that are zero if it never
+// was
+type ServiceScalingHistory struct {
+	ServiceID    string
+	LastScaleOut int64
+	LastScaleIn  int64
 }
 
 // ServiceStagedScaling represents a scale out/in operation that has been staged to be completed
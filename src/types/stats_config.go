@@ -0,0 +1,36 @@
+package types
+
+// StatsConfig represents the stats section of the ServicesConfig object, which selects where the stats of the
+// containers are fetched from
+type StatsConfig struct {
+	Source string `json:"source"`
+	// Endpoint is the address of the docker engine or agent of every node, where {id}, {hostname} and {ip} are
+	// replaced by the ID, hostname and IP address of the node, e.g. tcp://{ip}:2376 or http://{ip}:9324
+	Endpoint string `json:"endpoint"`
+	// Endpoints overrides Endpoint for the nodes whose hostname or ID is a key of the map
+	Endpoints map[string]string `json:"endpoints"`
+	TLS       TLSConfig         `json:"tls"`
+}
+
+const (
+	// StatsSourceLocal fetches the stats of every container from the docker engine the autoscaler is connected to
+	StatsSourceLocal = "local"
+	// StatsSourceEngine fetches the stats of every container from the docker engine of the node it runs on
+	StatsSourceEngine = "engine"
+	// StatsSourceAgent fetches the stats of every container from the agent running on its node
+	StatsSourceAgent = "agent"
+)
+
+// TLSConfig represents the certificates used to connect to the docker engines or agents of the nodes, TLS is disabled
+// when none is set
+type TLSConfig struct {
+	CA                 string `json:"ca"`
+	Cert               string `json:"cert"`
+	Key                string `json:"key"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// Enabled reports whether any certificate is configured
+func (t TLSConfig) Enabled() bool {
+	return t.CA != "" || t.Cert != "" || t.Key != ""
+}
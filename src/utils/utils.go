@@ -12,16 +12,71 @@ func PanicOnError(err error) {
 }
 
 // ExtractContainerResourceUsage parses a ContainerStatsRaw object and extracts a ContainerResourceUsage object
-func ExtractContainerResourceUsage(stats types.ContainerStatsRaw) types.ContainerResourceUsage {
-	cpu := 0.0
+//
+// If nanoCPUsLimit is not zero the cpu usage is expressed as a percentage of that limit, otherwise as a percentage of a
+// single host cpu
+func ExtractContainerResourceUsage(stats types.ContainerStatsRaw, nanoCPUsLimit int64) types.ContainerResourceUsage {
+	return types.ContainerResourceUsage{
+		CPU:    extractCPUUsage(stats, nanoCPUsLimit),
+		Memory: extractMemoryUsage(stats),
+	}
+}
+
+// extractCPUUsage
+func extractCPUUsage(stats types.ContainerStatsRaw, nanoCPUsLimit int64) float64 {
+	// the first sample of a container has no previous cpu stats to compare with
+	if stats.PreCPUStats.SystemCPUUsage == 0 {
+		return 0.0
+	}
+
+	// the counters go backwards when the container restarts or the counters are reset
+	if stats.CPUStats.CPUUsage.TotalUsage < stats.PreCPUStats.CPUUsage.TotalUsage ||
+		stats.CPUStats.SystemCPUUsage < stats.PreCPUStats.SystemCPUUsage {
+		return 0.0
+	}
+
 	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage - stats.PreCPUStats.CPUUsage.TotalUsage)
 	systemCPUDelta := float64(stats.CPUStats.SystemCPUUsage - stats.PreCPUStats.SystemCPUUsage)
 
-	if cpuDelta > 0.0 && systemCPUDelta > 0.0 {
-		cpu = (cpuDelta / systemCPUDelta) * float64(len(stats.CPUStats.CPUUsage.PerCPUUsage)) * 100.0
+	if cpuDelta == 0.0 || systemCPUDelta == 0.0 {
+		return 0.0
 	}
 
-	return types.ContainerResourceUsage{
-		CPU: cpu,
+	// percpu_usage is not reported on cgroup v2 hosts and by newer docker engines
+	onlineCPUs := float64(stats.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0.0 {
+		onlineCPUs = float64(len(stats.CPUStats.CPUUsage.PerCPUUsage))
 	}
+
+	usedCPUs := (cpuDelta / systemCPUDelta) * onlineCPUs
+
+	if nanoCPUsLimit > 0 {
+		return usedCPUs / (float64(nanoCPUsLimit) / 1e9) * 100.0
+	}
+
+	return usedCPUs * 100.0
+}
+
+// extractMemoryUsage calculates the memory used by a container, excluding the reclaimable page cache, as a
+// percentage of its memory limit
+func extractMemoryUsage(stats types.ContainerStatsRaw) float64 {
+	memoryStats := stats.MemoryStats
+
+	if memoryStats.Limit <= 0 {
+		return 0.0
+	}
+
+	used := memoryStats.Usage
+
+	// cgroup v1 reports total_inactive_file (and cache on older kernels) while cgroup v2 only reports inactive_file
+	switch {
+	case memoryStats.Stats.TotalInactiveFile > 0 && memoryStats.Stats.TotalInactiveFile < used:
+		used -= memoryStats.Stats.TotalInactiveFile
+	case memoryStats.Stats.InactiveFile > 0 && memoryStats.Stats.InactiveFile < used:
+		used -= memoryStats.Stats.InactiveFile
+	case memoryStats.Stats.Cache > 0 && memoryStats.Stats.Cache < used:
+		used -= memoryStats.Stats.Cache
+	}
+
+	return float64(used) / float64(memoryStats.Limit) * 100.0
 }
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"../types"
+)
+
+// parseStats decodes stats as the docker engine reports them
+func parseStats(t *testing.T, data string) types.ContainerStatsRaw {
+	var stats types.ContainerStatsRaw
+
+	if err := json.Unmarshal([]byte(data), &stats); err != nil {
+		t.Fatalf("cannot parse stats %s: %s", data, err)
+	}
+
+	return stats
+}
+
+func TestExtractMemoryUsage(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats string
+		want  float64
+	}{
+		{
+			name:  "no page cache reported",
+			stats: `{"memory_stats": {"usage": 250, "limit": 1000}}`,
+			want:  25,
+		},
+		{
+			name:  "cgroup v1",
+			stats: `{"memory_stats": {"usage": 600, "limit": 1000, "stats": {"total_inactive_file": 100, "cache": 300}}}`,
+			want:  50,
+		},
+		{
+			name:  "cgroup v2",
+			stats: `{"memory_stats": {"usage": 600, "limit": 1000, "stats": {"inactive_file": 200}}}`,
+			want:  40,
+		},
+		{
+			name:  "cache of older kernels",
+			stats: `{"memory_stats": {"usage": 600, "limit": 1000, "stats": {"cache": 100}}}`,
+			want:  50,
+		},
+		{
+			name:  "cgroup v1 inactive file larger than usage",
+			stats: `{"memory_stats": {"usage": 300, "limit": 1000, "stats": {"total_inactive_file": 500}}}`,
+			want:  30,
+		},
+		{
+			name:  "cgroup v2 inactive file as large as usage",
+			stats: `{"memory_stats": {"usage": 300, "limit": 1000, "stats": {"inactive_file": 300}}}`,
+			want:  30,
+		},
+		{
+			name:  "cache larger than usage",
+			stats: `{"memory_stats": {"usage": 300, "limit": 1000, "stats": {"cache": 400}}}`,
+			want:  30,
+		},
+		{
+			name:  "zero limit",
+			stats: `{"memory_stats": {"usage": 600, "limit": 0, "stats": {"inactive_file": 200}}}`,
+			want:  0,
+		},
+		{
+			name:  "no memory stats",
+			stats: `{}`,
+			want:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractMemoryUsage(parseStats(t, tt.stats)); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got memory usage %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractCPUUsage(t *testing.T) {
+	// one cpu worth of usage out of the 4e9ns the host cpus ran for between the two samples
+	const precpu = `"precpu_stats": {"cpu_usage": {"total_usage": 1000000000}, "system_cpu_usage": 10000000000}`
+
+	tests := []struct {
+		name          string
+		stats         string
+		nanoCPUsLimit int64
+		want          float64
+	}{
+		{
+			name: "online cpus",
+			stats: `{` + precpu + `, "cpu_stats": {"cpu_usage": {"total_usage": 2000000000},
+				"system_cpu_usage": 14000000000, "online_cpus": 4}}`,
+			want: 100,
+		},
+		{
+			name: "online cpus over per cpu usage",
+			stats: `{` + precpu + `, "cpu_stats": {"cpu_usage": {"total_usage": 2000000000, "percpu_usage": [1, 1]},
+				"system_cpu_usage": 14000000000, "online_cpus": 4}}`,
+			want: 100,
+		},
+		{
+			name: "per cpu usage without online cpus",
+			stats: `{` + precpu + `, "cpu_stats": {"cpu_usage": {"total_usage": 2000000000, "percpu_usage": [1, 1]},
+				"system_cpu_usage": 14000000000}}`,
+			want: 50,
+		},
+		{
+			name:  "first sample",
+			stats: `{"cpu_stats": {"cpu_usage": {"total_usage": 2000000000}, "system_cpu_usage": 14000000000, "online_cpus": 4}}`,
+			want:  0,
+		},
+		{
+			name: "cpu usage counter reset",
+			stats: `{` + precpu + `, "cpu_stats": {"cpu_usage": {"total_usage": 500000000},
+				"system_cpu_usage": 14000000000, "online_cpus": 4}}`,
+			want: 0,
+		},
+		{
+			name: "system cpu usage counter reset",
+			stats: `{` + precpu + `, "cpu_stats": {"cpu_usage": {"total_usage": 2000000000},
+				"system_cpu_usage": 9000000000, "online_cpus": 4}}`,
+			want: 0,
+		},
+		{
+			name: "no system cpu usage delta",
+			stats: `{` + precpu + `, "cpu_stats": {"cpu_usage": {"total_usage": 2000000000},
+				"system_cpu_usage": 10000000000, "online_cpus": 4}}`,
+			want: 0,
+		},
+		{
+			name: "idle",
+			stats: `{` + precpu + `, "cpu_stats": {"cpu_usage": {"total_usage": 1000000000},
+				"system_cpu_usage": 14000000000, "online_cpus": 4}}`,
+			want: 0,
+		},
+		{
+			name: "relative to a limit of 2 cpus",
+			stats: `{` + precpu + `, "cpu_stats": {"cpu_usage": {"total_usage": 2000000000},
+				"system_cpu_usage": 14000000000, "online_cpus": 4}}`,
+			nanoCPUsLimit: 2e9,
+			want:          50,
+		},
+		{
+			name: "relative to a limit of half a cpu",
+			stats: `{` + precpu + `, "cpu_stats": {"cpu_usage": {"total_usage": 2000000000},
+				"system_cpu_usage": 14000000000, "online_cpus": 4}}`,
+			nanoCPUsLimit: 5e8,
+			want:          200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractCPUUsage(parseStats(t, tt.stats), tt.nanoCPUsLimit); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got cpu usage %f, want %f", got, tt.want)
+			}
+		})
+	}
+}